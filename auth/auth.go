@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrMissingCredentials = errors.New("missing credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+const (
+	MethodAPIKey = "apikey"
+	MethodJWT    = "jwt"
+	MethodMTLS   = "mtls"
+	MethodNone   = "none"
+)
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Subject string
+	Method  string
+	// Roles are granted on every resource, independent of stored policies.
+	Roles []string
+}
+
+type Authenticator struct {
+	disabled    bool
+	clientCerts bool
+	apiKeys     map[[sha256.Size]byte]*Identity
+	secret      []byte
+	keys        map[string]*rsa.PublicKey
+	issuer      string
+	audience    string
+}
+
+// New builds an Authenticator from the environment:
+//
+//	AUTH_DISABLED      accept every request as an anonymous caller
+//	AUTH_API_KEYS      comma separated name:key[:role] entries
+//	AUTH_JWT_SECRET    shared secret for HS256 tokens
+//	AUTH_JWKS_FILE     JWKS file with the public keys for RS256 tokens
+//	AUTH_JWT_ISSUER    required iss claim, if set
+//	AUTH_JWT_AUDIENCE  required aud claim, if set
+func New() (*Authenticator, error) {
+	a := &Authenticator{
+		apiKeys:  map[[sha256.Size]byte]*Identity{},
+		secret:   []byte(os.Getenv("AUTH_JWT_SECRET")),
+		issuer:   os.Getenv("AUTH_JWT_ISSUER"),
+		audience: os.Getenv("AUTH_JWT_AUDIENCE"),
+	}
+
+	if v := os.Getenv("AUTH_DISABLED"); v != "" {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_DISABLED: %w", err)
+		}
+		a.disabled = disabled
+	}
+
+	if v := os.Getenv("AUTH_API_KEYS"); v != "" {
+		for _, entry := range strings.Split(v, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+			if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("AUTH_API_KEYS: malformed entry %q, expected name:key[:role]", entry)
+			}
+
+			identity := &Identity{Subject: parts[0], Method: MethodAPIKey}
+			if len(parts) == 3 {
+				if !IsRole(parts[2]) {
+					return nil, fmt.Errorf("AUTH_API_KEYS: unknown role %q", parts[2])
+				}
+				identity.Roles = []string{parts[2]}
+			}
+			a.apiKeys[sha256.Sum256([]byte(parts[1]))] = identity
+		}
+	}
+
+	if path := os.Getenv("AUTH_JWKS_FILE"); path != "" {
+		keys, err := loadJWKS(path)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_JWKS_FILE: %w", err)
+		}
+		a.keys = keys
+	}
+
+	return a, nil
+}
+
+// TrustClientCertificates accepts verified TLS client certificates as
+// credentials. It is only safe when the server verifies them.
+func (a *Authenticator) TrustClientCertificates() {
+	a.clientCerts = true
+}
+
+// Configured reports whether any way of authenticating is set up. Without one
+// every request is rejected.
+func (a *Authenticator) Configured() bool {
+	return a.disabled || a.clientCerts || len(a.apiKeys) > 0 || len(a.secret) > 0 || len(a.keys) > 0
+}
+
+// Authenticate resolves the caller from an X-API-Key header or a bearer JWT in
+// the Authorization header. Without either, a verified client certificate
+// identifies the caller as mtls:<name>, where name is its common name, or
+// else its first DNS name or URI. Its permissions come from the policies for
+// that subject; the prefix keeps certificates from taking on the policies of
+// an API key or token subject with the same name.
+func (a *Authenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if a.disabled {
+		return &Identity{Subject: "anonymous", Method: MethodNone, Roles: []string{RoleAdmin}}, nil
+	}
+
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		identity, ok := a.apiKeys[sha256.Sum256([]byte(key))]
+		if !ok {
+			return nil, ErrInvalidCredentials
+		}
+		return identity, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		if identity, ok := a.clientCertificate(r); ok {
+			return identity, nil
+		}
+		return nil, ErrMissingCredentials
+	}
+
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrInvalidCredentials
+	}
+
+	claims, err := a.verifyJWT(strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{Subject: claims.Subject, Method: MethodJWT, Roles: claims.Roles}, nil
+}
+
+func (a *Authenticator) clientCertificate(r *http.Request) (*Identity, bool) {
+	if !a.clientCerts || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, false
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	subject := cert.Subject.CommonName
+	if subject == "" && len(cert.DNSNames) > 0 {
+		subject = cert.DNSNames[0]
+	}
+	if subject == "" && len(cert.URIs) > 0 {
+		subject = cert.URIs[0].String()
+	}
+	if subject == "" {
+		return nil, false
+	}
+
+	return &Identity{Subject: MethodMTLS + ":" + subject, Method: MethodMTLS}, true
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
+
+type revealKey struct{}
+
+// WithReveal marks ctx as allowed to see secret values in the clear.
+func WithReveal(ctx context.Context) context.Context {
+	return context.WithValue(ctx, revealKey{}, true)
+}
+
+func CanReveal(ctx context.Context) bool {
+	reveal, _ := ctx.Value(revealKey{}).(bool)
+	return reveal
+}
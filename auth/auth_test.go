@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestClientCertificate(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/worker")
+
+	tests := []struct {
+		name    string
+		trusted bool
+		cert    *x509.Certificate
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "common name",
+			trusted: true,
+			cert:    &x509.Certificate{Subject: pkix.Name{CommonName: "worker"}, DNSNames: []string{"worker.example.org"}},
+			want:    "mtls:worker",
+		},
+		{
+			name:    "DNS name without common name",
+			trusted: true,
+			cert:    &x509.Certificate{DNSNames: []string{"worker.example.org"}},
+			want:    "mtls:worker.example.org",
+		},
+		{
+			name:    "URI without common name or DNS name",
+			trusted: true,
+			cert:    &x509.Certificate{URIs: []*url.URL{spiffe}},
+			want:    "mtls:spiffe://example.org/worker",
+		},
+		{
+			name:    "certificate without a name",
+			trusted: true,
+			cert:    &x509.Certificate{},
+			wantErr: ErrMissingCredentials,
+		},
+		{
+			name:    "certificates not trusted",
+			cert:    &x509.Certificate{Subject: pkix.Name{CommonName: "worker"}},
+			wantErr: ErrMissingCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Authenticator{clientCerts: tt.trusted}
+
+			r := httptest.NewRequest("GET", "/configs/", nil)
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.cert}}}
+
+			identity, err := a.Authenticate(r)
+			if err != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if identity.Subject != tt.want || identity.Method != MethodMTLS {
+				t.Errorf("Authenticate() = %s/%s, want %s/%s", identity.Method, identity.Subject, MethodMTLS, tt.want)
+			}
+		})
+	}
+}
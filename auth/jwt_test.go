@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// signJWT builds a token from header and claims, signed with HS256 under
+// secret or with RS256 under key.
+func signJWT(t *testing.T, header map[string]string, claims map[string]interface{}, secret []byte, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := segment(header) + "." + segment(claims)
+
+	var signature []byte
+	switch header["alg"] {
+	case "HS256":
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	secret := []byte("shared-secret")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Authenticator{
+		secret:   secret,
+		keys:     map[string]*rsa.PublicKey{"k1": &key.PublicKey},
+		issuer:   "https://issuer.example.org",
+		audience: "config_service",
+	}
+
+	hs256 := map[string]string{"alg": "HS256", "typ": "JWT"}
+	rs256 := map[string]string{"alg": "RS256", "kid": "k1"}
+
+	now := time.Now()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":   "alice",
+			"iss":   "https://issuer.example.org",
+			"aud":   "config_service",
+			"exp":   now.Add(time.Hour).Unix(),
+			"roles": []string{RoleReader},
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		scheme  string
+		wantErr bool
+	}{
+		{name: "HS256", token: signJWT(t, hs256, claims(nil), secret, nil)},
+		{name: "RS256", token: signJWT(t, rs256, claims(nil), nil, key)},
+		{name: "audience list", token: signJWT(t, hs256, claims(map[string]interface{}{"aud": []string{"other", "config_service"}}), secret, nil)},
+		{name: "lowercase scheme", scheme: "bearer", token: signJWT(t, hs256, claims(nil), secret, nil)},
+		{name: "expired within leeway", token: signJWT(t, hs256, claims(map[string]interface{}{"exp": now.Add(-leeway / 2).Unix()}), secret, nil)},
+		{name: "expired", token: signJWT(t, hs256, claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}), secret, nil), wantErr: true},
+		{name: "not yet valid", token: signJWT(t, hs256, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}), secret, nil), wantErr: true},
+		{name: "wrong issuer", token: signJWT(t, hs256, claims(map[string]interface{}{"iss": "https://evil.example.org"}), secret, nil), wantErr: true},
+		{name: "wrong audience", token: signJWT(t, hs256, claims(map[string]interface{}{"aud": "other"}), secret, nil), wantErr: true},
+		{name: "no subject", token: signJWT(t, hs256, claims(map[string]interface{}{"sub": nil}), secret, nil), wantErr: true},
+		{name: "bad HS256 signature", token: signJWT(t, hs256, claims(nil), []byte("other-secret"), nil), wantErr: true},
+		{name: "bad RS256 signature", token: signJWT(t, rs256, claims(nil), nil, other), wantErr: true},
+		{name: "unknown kid", token: signJWT(t, map[string]string{"alg": "RS256", "kid": "k2"}, claims(nil), nil, key), wantErr: true},
+		{name: "alg none", token: signJWT(t, map[string]string{"alg": "none"}, claims(nil), nil, nil), wantErr: true},
+		{name: "malformed", token: "not.a-token", wantErr: true},
+		{name: "basic scheme", scheme: "Basic", token: signJWT(t, hs256, claims(nil), secret, nil), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := tt.scheme
+			if scheme == "" {
+				scheme = "Bearer"
+			}
+
+			r := httptest.NewRequest("GET", "/configs/", nil)
+			r.Header.Set("Authorization", scheme+" "+tt.token)
+
+			identity, err := a.Authenticate(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if identity.Subject != "alice" || identity.Method != MethodJWT || len(identity.Roles) != 1 || identity.Roles[0] != RoleReader {
+				t.Errorf("Authenticate() = %+v, want alice with the reader role", identity)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	t.Setenv("AUTH_API_KEYS", "ci:ci-key:editor, ops:ops-key")
+
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		want      string
+		wantRoles []string
+		wantErr   error
+	}{
+		{name: "with role", key: "ci-key", want: "ci", wantRoles: []string{RoleEditor}},
+		{name: "without role", key: "ops-key", want: "ops"},
+		{name: "unknown key", key: "guess", wantErr: ErrInvalidCredentials},
+		{name: "no key", wantErr: ErrMissingCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/configs/", nil)
+			if tt.key != "" {
+				r.Header.Set("X-API-Key", tt.key)
+			}
+
+			identity, err := a.Authenticate(r)
+			if err != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if identity.Subject != tt.want || identity.Method != MethodAPIKey || len(identity.Roles) != len(tt.wantRoles) {
+				t.Errorf("Authenticate() = %+v, want %s with roles %v", identity, tt.want, tt.wantRoles)
+			}
+		})
+	}
+}
+
+func TestNewRejectsMalformedAPIKeys(t *testing.T) {
+	for _, v := range []string{"no-key", ":key", "name:", "name:key:superuser"} {
+		t.Run(v, func(t *testing.T) {
+			t.Setenv("AUTH_API_KEYS", v)
+			if _, err := New(); err == nil {
+				t.Errorf("New() with AUTH_API_KEYS=%q succeeded", v)
+			}
+		})
+	}
+}
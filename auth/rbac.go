@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"ars-projekat/model"
+)
+
+const (
+	RoleReader   = "reader"
+	RoleRevealer = "revealer"
+	RoleEditor   = "editor"
+	RoleAdmin    = "admin"
+)
+
+const (
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionDelete = "delete"
+	ActionAdmin  = "admin"
+	// ActionReveal allows reading secret values in the clear.
+	ActionReveal = "reveal"
+)
+
+const (
+	ResourceConfig = "configs"
+	ResourceGroup  = "groups"
+	ResourceAdmin  = "admin"
+)
+
+var rolePermissions = map[string][]string{
+	RoleReader:   {ActionRead},
+	RoleRevealer: {ActionRead, ActionReveal},
+	RoleEditor:   {ActionRead, ActionWrite},
+	RoleAdmin:    {ActionRead, ActionWrite, ActionDelete, ActionAdmin, ActionReveal},
+}
+
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Resource is what a request acts on. Id is empty when a new config or group
+// is being created and Namespace is empty outside of a namespace. LabelSets
+// holds the label sets of the group configs the request reads or changes, as
+// stored or as sent in the body, never as claimed in the query.
+type Resource struct {
+	Kind      string
+	Id        string
+	LabelSets [][]model.LabelJSON
+	Namespace string
+}
+
+// Authorize reports whether identity may perform action on resource, either
+// through one of its own roles or through a stored policy naming its subject.
+// Roles only apply outside of namespaces; inside one, access takes a policy
+// for that namespace, so tenants stay apart whatever their callers' roles.
+func Authorize(identity *Identity, policies []*model.PolicyJSON, action string, resource Resource) bool {
+	if resource.Namespace == "" {
+		for _, role := range identity.Roles {
+			if roleAllows(role, action) {
+				return true
+			}
+		}
+	}
+
+	for _, p := range policies {
+		if p.Subject != identity.Subject || !roleAllows(p.Role, action) {
+			continue
+		}
+		if policyCovers(p, resource) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LabelScoped reports whether one of the policies of identity has a label
+// selector, so deciding on it needs the labels of the resource.
+func LabelScoped(identity *Identity, policies []*model.PolicyJSON) bool {
+	for _, p := range policies {
+		if p.Subject == identity.Subject && len(p.Labels) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func roleAllows(role string, action string) bool {
+	return containsString(rolePermissions[role], action)
+}
+
+// policyCovers checks the scope of a policy. A policy only applies within its
+// own namespace, and one without a namespace only outside of them. An
+// unscoped policy covers everything there. Otherwise the resource has to be
+// one of the listed configs or groups, when any are listed, and every config
+// of it the request touches has to carry every label of the selector, when
+// one is given. Configs have no labels, so a selector never covers them.
+// Admin endpoints and creation of new ids need an unscoped policy.
+func policyCovers(p *model.PolicyJSON, resource Resource) bool {
+	if p.Namespace != resource.Namespace {
+		return false
+	}
+
+	if len(p.Configs) == 0 && len(p.Groups) == 0 && len(p.Labels) == 0 {
+		return true
+	}
+
+	if resource.Kind == ResourceAdmin || resource.Id == "" {
+		return false
+	}
+
+	if len(p.Configs) > 0 || len(p.Groups) > 0 {
+		listed := (resource.Kind == ResourceConfig && containsString(p.Configs, resource.Id)) ||
+			(resource.Kind == ResourceGroup && containsString(p.Groups, resource.Id))
+		if !listed {
+			return false
+		}
+	}
+
+	if len(p.Labels) > 0 {
+		if resource.Kind != ResourceGroup || len(resource.LabelSets) == 0 {
+			return false
+		}
+
+		for _, labels := range resource.LabelSets {
+			for _, selector := range p.Labels {
+				if !containsLabel(labels, selector) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+func containsLabel(labels []model.LabelJSON, label model.LabelJSON) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+
+	return false
+}
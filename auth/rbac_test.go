@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"ars-projekat/model"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	payments := model.LabelJSON{Key: "team", Value: "payments"}
+	prod := model.LabelJSON{Key: "env", Value: "prod"}
+	search := model.LabelJSON{Key: "team", Value: "search"}
+
+	alice := &Identity{Subject: "alice"}
+
+	tests := []struct {
+		name     string
+		identity *Identity
+		policies []*model.PolicyJSON
+		action   string
+		resource Resource
+		want     bool
+	}{
+		{
+			name:     "role allows action",
+			identity: &Identity{Subject: "bob", Roles: []string{RoleEditor}},
+			action:   ActionWrite,
+			resource: Resource{Kind: ResourceConfig, Id: "c1"},
+			want:     true,
+		},
+		{
+			name:     "role does not allow action",
+			identity: &Identity{Subject: "bob", Roles: []string{RoleReader}},
+			action:   ActionDelete,
+			resource: Resource{Kind: ResourceConfig, Id: "c1"},
+			want:     false,
+		},
+		{
+			name:     "no role and no policy",
+			identity: alice,
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceConfig, Id: "c1"},
+			want:     false,
+		},
+		{
+			name:     "unscoped policy",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleReader}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceConfig, Id: "c1"},
+			want:     true,
+		},
+		{
+			name:     "policy of another subject",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "bob", Role: RoleAdmin}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceConfig, Id: "c1"},
+			want:     false,
+		},
+		{
+			name:     "policy role does not allow action",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleReader}},
+			action:   ActionWrite,
+			resource: Resource{Kind: ResourceConfig, Id: "c1"},
+			want:     false,
+		},
+		{
+			name:     "listed config",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleReader, Configs: []string{"c1"}}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceConfig, Id: "c1"},
+			want:     true,
+		},
+		{
+			name:     "unlisted config",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleReader, Configs: []string{"c1"}}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceConfig, Id: "c2"},
+			want:     false,
+		},
+		{
+			name:     "listed config id does not cover a group with that id",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleReader, Configs: []string{"c1"}}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceGroup, Id: "c1"},
+			want:     false,
+		},
+		{
+			name:     "scoped policy does not allow creation",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleEditor, Groups: []string{"g1"}}},
+			action:   ActionWrite,
+			resource: Resource{Kind: ResourceGroup},
+			want:     false,
+		},
+		{
+			name:     "scoped policy does not cover admin",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleAdmin, Groups: []string{"g1"}}},
+			action:   ActionAdmin,
+			resource: Resource{Kind: ResourceAdmin},
+			want:     false,
+		},
+		{
+			name:     "label selector never covers a config",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleAdmin, Labels: []model.LabelJSON{payments}}},
+			action:   ActionDelete,
+			resource: Resource{Kind: ResourceConfig, Id: "c1"},
+			want:     false,
+		},
+		{
+			name:     "label selector needs the labels of the group",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleAdmin, Labels: []model.LabelJSON{payments}}},
+			action:   ActionDelete,
+			resource: Resource{Kind: ResourceGroup, Id: "g1"},
+			want:     false,
+		},
+		{
+			name:     "label selector on a group without configs",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleAdmin, Labels: []model.LabelJSON{payments}}},
+			action:   ActionDelete,
+			resource: Resource{Kind: ResourceGroup, Id: "g1", LabelSets: [][]model.LabelJSON{}},
+			want:     false,
+		},
+		{
+			name:     "every config carries the selector",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleAdmin, Labels: []model.LabelJSON{payments}}},
+			action:   ActionDelete,
+			resource: Resource{Kind: ResourceGroup, Id: "g1", LabelSets: [][]model.LabelJSON{{payments}, {payments, prod}}},
+			want:     true,
+		},
+		{
+			name:     "one config lacks the selector",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleAdmin, Labels: []model.LabelJSON{payments}}},
+			action:   ActionDelete,
+			resource: Resource{Kind: ResourceGroup, Id: "g1", LabelSets: [][]model.LabelJSON{{payments}, {search}}},
+			want:     false,
+		},
+		{
+			name:     "one config without labels",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleReader, Labels: []model.LabelJSON{payments}}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceGroup, Id: "g1", LabelSets: [][]model.LabelJSON{{payments}, nil}},
+			want:     false,
+		},
+		{
+			name:     "every label of the selector is needed",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleReader, Labels: []model.LabelJSON{payments, prod}}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceGroup, Id: "g1", LabelSets: [][]model.LabelJSON{{payments}}},
+			want:     false,
+		},
+		{
+			name:     "listed group and selector",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleReader, Groups: []string{"g1"}, Labels: []model.LabelJSON{payments}}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceGroup, Id: "g2", LabelSets: [][]model.LabelJSON{{payments}}},
+			want:     false,
+		},
+		{
+			name:     "role does not reach into a namespace",
+			identity: &Identity{Subject: "bob", Roles: []string{RoleAdmin}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceConfig, Id: "c1", Namespace: "team-a"},
+			want:     false,
+		},
+		{
+			name:     "policy for the namespace",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleEditor, Namespace: "team-a"}},
+			action:   ActionWrite,
+			resource: Resource{Kind: ResourceConfig, Namespace: "team-a"},
+			want:     true,
+		},
+		{
+			name:     "policy for another namespace",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleEditor, Namespace: "team-b"}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceConfig, Id: "c1", Namespace: "team-a"},
+			want:     false,
+		},
+		{
+			name:     "policy outside namespaces does not reach into one",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleAdmin}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceConfig, Id: "c1", Namespace: "team-a"},
+			want:     false,
+		},
+		{
+			name:     "namespaced policy does not cover data outside namespaces",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleAdmin, Namespace: "team-a"}},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceConfig, Id: "c1"},
+			want:     false,
+		},
+		{
+			name:     "namespaced policy does not cover admin",
+			identity: alice,
+			policies: []*model.PolicyJSON{{Subject: "alice", Role: RoleAdmin, Namespace: "team-a"}},
+			action:   ActionAdmin,
+			resource: Resource{Kind: ResourceAdmin},
+			want:     false,
+		},
+		{
+			name:     "second policy covers",
+			identity: alice,
+			policies: []*model.PolicyJSON{
+				{Subject: "alice", Role: RoleReader, Groups: []string{"g2"}},
+				{Subject: "alice", Role: RoleReader, Groups: []string{"g1"}},
+			},
+			action:   ActionRead,
+			resource: Resource{Kind: ResourceGroup, Id: "g1"},
+			want:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Authorize(tt.identity, tt.policies, tt.action, tt.resource); got != tt.want {
+				t.Errorf("Authorize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelScoped(t *testing.T) {
+	payments := model.LabelJSON{Key: "team", Value: "payments"}
+
+	tests := []struct {
+		name     string
+		policies []*model.PolicyJSON
+		want     bool
+	}{
+		{name: "no policies", want: false},
+		{name: "unscoped", policies: []*model.PolicyJSON{{Subject: "alice"}}, want: false},
+		{name: "selector", policies: []*model.PolicyJSON{{Subject: "alice", Labels: []model.LabelJSON{payments}}}, want: true},
+		{name: "selector of another subject", policies: []*model.PolicyJSON{{Subject: "bob", Labels: []model.LabelJSON{payments}}}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LabelScoped(&Identity{Subject: "alice"}, tt.policies); got != tt.want {
+				t.Errorf("LabelScoped() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
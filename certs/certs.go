@@ -0,0 +1,274 @@
+package certs
+
+import (
+	"ars-projekat/config"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reloader holds the server certificate and the pool client certificates are
+// verified against, and loads them again when their files change, so
+// certificates can be renewed without a restart.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	logger   *slog.Logger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes []time.Time
+}
+
+// NewReloader loads the certificate and key, and the client CA file when
+// caFile is set.
+func NewReloader(certFile, keyFile, caFile string, logger *slog.Logger) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile, logger: logger}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+func (r *Reloader) reload() error {
+	modTimes, err := r.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.pool = pool
+	r.modTimes = modTimes
+
+	return nil
+}
+
+func (r *Reloader) stat() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	return modTimes, nil
+}
+
+func (r *Reloader) changed() bool {
+	modTimes, err := r.stat()
+	if err != nil {
+		// A file being replaced may be missing for a moment; the next check
+		// picks it up.
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := range modTimes {
+		if !modTimes[i].Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the files every interval when they have changed, until ctx
+// is done. Files that fail to load leave the previous ones in use.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+
+			if err := r.reload(); err != nil {
+				r.logger.Error("reloading TLS certificates failed", slog.Any("error", err))
+				continue
+			}
+			r.logger.Info("reloaded TLS certificates", slog.Any("files", r.files()))
+		}
+	}
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, r.pool
+}
+
+// ServerConfig returns a TLS configuration that picks up the current files on
+// every handshake, and the error log for the server using it. Every handshake
+// is recorded as a span: a completed one once it passes verification, a failed
+// one when the server logs its error.
+func (r *Reloader) ServerConfig(clientAuth tls.ClientAuthType, tracer opentracing.Tracer) (*tls.Config, *log.Logger) {
+	h := &handshakes{tracer: tracer, logger: r.logger}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			addr := h.start(hello)
+			cert, pool := r.current()
+
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{"h2", "http/1.1"},
+				Certificates: []tls.Certificate{*cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    pool,
+				VerifyConnection: func(cs tls.ConnectionState) error {
+					h.verified(addr, cs)
+					return nil
+				},
+			}, nil
+		},
+	}
+
+	return config, log.New(h, "", 0)
+}
+
+// handshakes holds the span of every handshake in progress, by the address of
+// the client. VerifyConnection is only called when the client certificate
+// passed verification, so failures are picked up from the error the server
+// logs for them instead.
+type handshakes struct {
+	tracer  opentracing.Tracer
+	logger  *slog.Logger
+	pending sync.Map
+}
+
+const handshakeErrorPrefix = "http: TLS handshake error from "
+
+func (h *handshakes) start(hello *tls.ClientHelloInfo) string {
+	span := h.tracer.StartSpan("TLSHandshake")
+	span.SetTag("tls.server_name", hello.ServerName)
+
+	if hello.Conn == nil {
+		return ""
+	}
+
+	addr := hello.Conn.RemoteAddr().String()
+	span.SetTag("peer.address", addr)
+	if previous, loaded := h.pending.Swap(addr, span); loaded {
+		previous.(opentracing.Span).Finish()
+	}
+
+	return addr
+}
+
+func (h *handshakes) verified(addr string, cs tls.ConnectionState) {
+	v, ok := h.pending.LoadAndDelete(addr)
+	if !ok {
+		return
+	}
+
+	span := v.(opentracing.Span)
+	defer span.Finish()
+
+	span.SetTag("tls.version", tls.VersionName(cs.Version))
+	span.SetTag("tls.cipher", tls.CipherSuiteName(cs.CipherSuite))
+	span.SetTag("tls.resumed", cs.DidResume)
+	if len(cs.PeerCertificates) > 0 {
+		span.SetTag("tls.client.subject", cs.PeerCertificates[0].Subject.String())
+	}
+}
+
+// failed ends the span of the handshake with addr with an error. Handshakes
+// that failed before the ClientHello was read get a span of their own.
+func (h *handshakes) failed(addr string, msg string) {
+	var span opentracing.Span
+	if v, ok := h.pending.LoadAndDelete(addr); ok {
+		span = v.(opentracing.Span)
+	} else {
+		span = h.tracer.StartSpan("TLSHandshake")
+		span.SetTag("peer.address", addr)
+	}
+	defer span.Finish()
+
+	ext.Error.Set(span, true)
+	span.LogFields(otlog.String("error", msg))
+}
+
+// Write receives the lines of the server error log. Handshake errors end
+// their span; every line is passed on to the logger.
+func (h *handshakes) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+
+	if rest, ok := strings.CutPrefix(line, handshakeErrorPrefix); ok {
+		if addr, msg, ok := strings.Cut(rest, ": "); ok {
+			h.failed(addr, msg)
+		}
+	}
+	h.logger.Warn(line)
+
+	return len(p), nil
+}
+
+// ClientAuth maps the configured mode, none, optional or require, to the
+// policy of crypto/tls. Client certificates that are presented are always
+// verified.
+func ClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", config.ClientAuthNone:
+		return tls.NoClientCert, nil
+	case config.ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven, nil
+	case config.ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	}
+
+	return tls.NoClientCert, errors.New("unknown client auth mode " + mode)
+}
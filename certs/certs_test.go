@@ -0,0 +1,165 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) tls() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func (c *testCert) write(t *testing.T, dir string, name string) (string, string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestHandshakeSpans(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	server := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	client := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "worker"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	stranger := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "stranger"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil)
+
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := server.write(t, dir, "server")
+
+	reloader, err := NewReloader(certFile, keyFile, caFile, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := mocktracer.New()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS, srv.Config.ErrorLog = reloader.ServerConfig(tls.RequireAndVerifyClientCert, tracer)
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name      string
+		cert      *testCert
+		wantError bool
+	}{
+		{name: "trusted client certificate", cert: client, wantError: false},
+		{name: "untrusted client certificate", cert: stranger, wantError: true},
+		{name: "no client certificate", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer.Reset()
+
+			config := &tls.Config{RootCAs: roots}
+			if tt.cert != nil {
+				config.Certificates = []tls.Certificate{tt.cert.tls()}
+			}
+			httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+
+			resp, err := httpClient.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			// the server logs a failed handshake after the client sees it
+			deadline := time.Now().Add(5 * time.Second)
+			for len(tracer.FinishedSpans()) == 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			spans := tracer.FinishedSpans()
+			if len(spans) != 1 {
+				t.Fatalf("finished spans = %d, want 1", len(spans))
+			}
+			if spans[0].OperationName != "TLSHandshake" {
+				t.Errorf("operation = %q, want TLSHandshake", spans[0].OperationName)
+			}
+			if failed := spans[0].Tag("error") == true; failed != tt.wantError {
+				t.Errorf("error tag = %v, want %v", failed, tt.wantError)
+			}
+		})
+	}
+}
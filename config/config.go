@@ -0,0 +1,597 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every setting of the service. Load builds it from defaults,
+// then a YAML file, then environment variables, then flags, each overriding
+// the ones before.
+type Config struct {
+	Server  ServerConfig  `yaml:"server"`
+	Storage StorageConfig `yaml:"storage"`
+	Tracing TracingConfig `yaml:"tracing"`
+	Metrics MetricsConfig `yaml:"metrics"`
+	Logging LoggingConfig `yaml:"logging"`
+}
+
+type ServerConfig struct {
+	Addr              string        `yaml:"addr"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	// WriteTimeout bounds whole responses, exports included, so it is off by
+	// default.
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// DrainDelay is how long /readyz fails before shutdown stops accepting
+	// connections.
+	DrainDelay time.Duration `yaml:"drain_delay"`
+	// MaxBodyBytes bounds the request bodies read while authorizing, before
+	// the handler runs, for callers whose policies select group labels.
+	MaxBodyBytes int64     `yaml:"max_body_bytes"`
+	TLS          TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig turns on HTTPS when both files are set. The files are reloaded
+// every ReloadInterval when they have changed, 0 turning reloading off.
+// ClientAuth is none, optional or require, and client certificates are
+// verified against ClientCAFile.
+type TLSConfig struct {
+	CertFile       string        `yaml:"cert_file"`
+	KeyFile        string        `yaml:"key_file"`
+	ClientCAFile   string        `yaml:"client_ca_file"`
+	ClientAuth     string        `yaml:"client_auth"`
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+type StorageConfig struct {
+	Backend           string          `yaml:"backend"`
+	Consul            ConsulConfig    `yaml:"consul"`
+	Secrets           SecretsConfig   `yaml:"secrets"`
+	Tombstones        TombstoneConfig `yaml:"tombstones"`
+	InventoryInterval time.Duration   `yaml:"inventory_interval"`
+}
+
+// ConsulConfig describes the Consul cluster. Every key the store writes lives
+// under Prefix, so environments that share a cluster stay apart.
+type ConsulConfig struct {
+	Address    string          `yaml:"address"`
+	Token      string          `yaml:"token"`
+	TokenFile  string          `yaml:"token_file"`
+	Datacenter string          `yaml:"datacenter"`
+	Prefix     string          `yaml:"prefix"`
+	TLS        ConsulTLSConfig `yaml:"tls"`
+}
+
+// ConsulTLSConfig switches the Consul client to HTTPS. ServerName is the name
+// the Consul certificate is checked against when it differs from the address.
+type ConsulTLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// SecretsConfig points at the key-encryption keys. Without KEKFile secret
+// configs are refused.
+type SecretsConfig struct {
+	KEKFile          string   `yaml:"kek_file"`
+	KEKPreviousFiles []string `yaml:"kek_previous_files"`
+}
+
+type TombstoneConfig struct {
+	Retention     time.Duration `yaml:"retention"`
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+type TracingConfig struct {
+	ServiceName string `yaml:"service_name"`
+	// Exporter is otlp, jaeger or none. When empty it is picked from the
+	// OTEL_EXPORTER_OTLP_* and JAEGER_* variables.
+	Exporter string        `yaml:"exporter"`
+	Sampler  SamplerConfig `yaml:"sampler"`
+}
+
+// SamplerConfig chooses which traces are kept. Param is the decision for
+// const, the ratio for probabilistic and traces per second for ratelimiting.
+// Routes maps route templates to the ratio of their traces that is kept.
+type SamplerConfig struct {
+	Type        string             `yaml:"type"`
+	Param       float64            `yaml:"param"`
+	ParentBased bool               `yaml:"parent_based"`
+	Routes      map[string]float64 `yaml:"routes"`
+}
+
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+type LoggingConfig struct {
+	Level string `yaml:"level"`
+}
+
+const (
+	BackendConsul = "consul"
+
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+
+	ExporterOTLP   = "otlp"
+	ExporterJaeger = "jaeger"
+	ExporterNone   = "none"
+
+	SamplerConst         = "const"
+	SamplerProbabilistic = "probabilistic"
+	SamplerRateLimiting  = "ratelimiting"
+)
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:              "0.0.0.0:8000",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   10 * time.Second,
+			MaxBodyBytes:      4 << 20,
+			TLS: TLSConfig{
+				ClientAuth:     ClientAuthNone,
+				ReloadInterval: 30 * time.Second,
+			},
+		},
+		Storage: StorageConfig{
+			Backend: BackendConsul,
+			Consul: ConsulConfig{
+				Address: "127.0.0.1:8500",
+			},
+			Tombstones: TombstoneConfig{
+				Retention:     7 * 24 * time.Hour,
+				PurgeInterval: time.Hour,
+			},
+			InventoryInterval: time.Minute,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "config_service",
+			Sampler: SamplerConfig{
+				Param:       1,
+				ParentBased: true,
+				// keep scrapes and probes out of the tracing backend
+				Routes: map[string]float64{"/metrics": 0, "/healthz": 0, "/readyz": 0},
+			},
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Path:    "/metrics",
+		},
+		Logging: LoggingConfig{
+			Level: "info",
+		},
+	}
+}
+
+// Load reads the configuration for the command line args. The file comes
+// from -config or CONFIG_FILE and is optional.
+func Load(args []string) (*Config, error) {
+	// The first pass only finds the file, since flags have to be applied
+	// after it.
+	probe := flag.NewFlagSet("config", flag.ContinueOnError)
+	probe.SetOutput(discard{})
+	file := bindFlags(probe, Default())
+	if err := probe.Parse(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return nil, err
+	}
+
+	path := *file
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+
+	cfg := Default()
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	bindFlags(fs, cfg)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv reads the environment variables the service has always used,
+// next to the ones added with the configuration file.
+func (c *Config) applyEnv() error {
+	env := envReader{}
+
+	env.string("LISTEN_ADDR", &c.Server.Addr)
+	env.duration("READ_HEADER_TIMEOUT", &c.Server.ReadHeaderTimeout)
+	env.duration("READ_TIMEOUT", &c.Server.ReadTimeout)
+	env.duration("WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	env.duration("IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	env.duration("SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+	env.duration("SHUTDOWN_DRAIN_DELAY", &c.Server.DrainDelay)
+	env.int64("MAX_BODY_BYTES", &c.Server.MaxBodyBytes)
+	env.string("TLS_CERT_FILE", &c.Server.TLS.CertFile)
+	env.string("TLS_KEY_FILE", &c.Server.TLS.KeyFile)
+	env.string("TLS_CLIENT_CA_FILE", &c.Server.TLS.ClientCAFile)
+	env.string("TLS_CLIENT_AUTH", &c.Server.TLS.ClientAuth)
+	env.duration("TLS_RELOAD_INTERVAL", &c.Server.TLS.ReloadInterval)
+
+	env.string("STORAGE_BACKEND", &c.Storage.Backend)
+	env.string("CONSUL_HTTP_ADDR", &c.Storage.Consul.Address)
+	// DB and DBPORT predate CONSUL_HTTP_ADDR and still win over it.
+	if db, port := os.Getenv("DB"), os.Getenv("DBPORT"); db != "" || port != "" {
+		host, defaultPort, _ := net.SplitHostPort(c.Storage.Consul.Address)
+		if db == "" {
+			db = host
+		}
+		if port == "" {
+			port = defaultPort
+		}
+		c.Storage.Consul.Address = net.JoinHostPort(db, port)
+	}
+	env.string("CONSUL_HTTP_TOKEN", &c.Storage.Consul.Token)
+	env.string("CONSUL_HTTP_TOKEN_FILE", &c.Storage.Consul.TokenFile)
+	env.string("CONSUL_DATACENTER", &c.Storage.Consul.Datacenter)
+	env.string("CONSUL_KEY_PREFIX", &c.Storage.Consul.Prefix)
+	env.bool("CONSUL_HTTP_SSL", &c.Storage.Consul.TLS.Enabled)
+	env.string("CONSUL_CACERT", &c.Storage.Consul.TLS.CAFile)
+	env.string("CONSUL_CLIENT_CERT", &c.Storage.Consul.TLS.CertFile)
+	env.string("CONSUL_CLIENT_KEY", &c.Storage.Consul.TLS.KeyFile)
+	env.string("CONSUL_TLS_SERVER_NAME", &c.Storage.Consul.TLS.ServerName)
+	if v, ok := env.lookup("CONSUL_HTTP_SSL_VERIFY"); ok {
+		verify, err := strconv.ParseBool(v)
+		if err != nil {
+			env.fail("CONSUL_HTTP_SSL_VERIFY", err)
+		} else {
+			c.Storage.Consul.TLS.InsecureSkipVerify = !verify
+		}
+	}
+	env.string("SECRETS_KEK_FILE", &c.Storage.Secrets.KEKFile)
+	env.list("SECRETS_KEK_PREVIOUS_FILES", &c.Storage.Secrets.KEKPreviousFiles)
+	env.duration("TOMBSTONE_RETENTION", &c.Storage.Tombstones.Retention)
+	env.duration("TOMBSTONE_PURGE_INTERVAL", &c.Storage.Tombstones.PurgeInterval)
+	env.duration("INVENTORY_SCAN_INTERVAL", &c.Storage.InventoryInterval)
+
+	env.string("TRACING_SERVICE_NAME", &c.Tracing.ServiceName)
+	env.string("TRACING_EXPORTER", &c.Tracing.Exporter)
+	env.string("TRACING_SAMPLER", &c.Tracing.Sampler.Type)
+	env.float("TRACING_SAMPLER_PARAM", &c.Tracing.Sampler.Param)
+	env.bool("TRACING_SAMPLER_PARENT_BASED", &c.Tracing.Sampler.ParentBased)
+	env.routes("TRACING_SAMPLER_ROUTES", &c.Tracing.Sampler.Routes)
+
+	env.bool("METRICS_ENABLED", &c.Metrics.Enabled)
+	env.string("METRICS_PATH", &c.Metrics.Path)
+
+	env.string("LOG_LEVEL", &c.Logging.Level)
+
+	return errors.Join(env.errs...)
+}
+
+// bindFlags registers a flag for every setting, with the value cfg holds as
+// default, and returns the -config flag.
+func bindFlags(fs *flag.FlagSet, cfg *Config) *string {
+	file := fs.String("config", "", "path of a YAML configuration file")
+
+	fs.StringVar(&cfg.Server.Addr, "addr", cfg.Server.Addr, "address to listen on")
+	fs.DurationVar(&cfg.Server.ReadHeaderTimeout, "read-header-timeout", cfg.Server.ReadHeaderTimeout, "time allowed to read request headers")
+	fs.DurationVar(&cfg.Server.ReadTimeout, "read-timeout", cfg.Server.ReadTimeout, "time allowed to read a whole request")
+	fs.DurationVar(&cfg.Server.WriteTimeout, "write-timeout", cfg.Server.WriteTimeout, "time allowed to write a response, 0 for none")
+	fs.DurationVar(&cfg.Server.IdleTimeout, "idle-timeout", cfg.Server.IdleTimeout, "how long idle keep-alive connections stay open")
+	fs.DurationVar(&cfg.Server.ShutdownTimeout, "shutdown-timeout", cfg.Server.ShutdownTimeout, "time allowed for requests to finish on shutdown")
+	fs.DurationVar(&cfg.Server.DrainDelay, "drain-delay", cfg.Server.DrainDelay, "how long /readyz fails before shutdown starts")
+	fs.Int64Var(&cfg.Server.MaxBodyBytes, "max-body-bytes", cfg.Server.MaxBodyBytes, "largest request body read while authorizing")
+	fs.StringVar(&cfg.Server.TLS.CertFile, "tls-cert", cfg.Server.TLS.CertFile, "TLS certificate file")
+	fs.StringVar(&cfg.Server.TLS.KeyFile, "tls-key", cfg.Server.TLS.KeyFile, "TLS private key file")
+	fs.StringVar(&cfg.Server.TLS.ClientCAFile, "tls-client-ca", cfg.Server.TLS.ClientCAFile, "CA file client certificates are verified against")
+	fs.StringVar(&cfg.Server.TLS.ClientAuth, "tls-client-auth", cfg.Server.TLS.ClientAuth, "none, optional or require")
+
+	fs.StringVar(&cfg.Storage.Backend, "storage", cfg.Storage.Backend, "storage backend")
+	fs.StringVar(&cfg.Storage.Consul.Address, "consul-addr", cfg.Storage.Consul.Address, "Consul HTTP address")
+	fs.StringVar(&cfg.Storage.Consul.Datacenter, "consul-datacenter", cfg.Storage.Consul.Datacenter, "Consul datacenter, the agent's own when empty")
+	fs.StringVar(&cfg.Storage.Consul.Prefix, "consul-prefix", cfg.Storage.Consul.Prefix, "root key prefix, such as prod/config-service")
+	fs.BoolVar(&cfg.Storage.Consul.TLS.Enabled, "consul-tls", cfg.Storage.Consul.TLS.Enabled, "connect to Consul over HTTPS")
+	fs.StringVar(&cfg.Storage.Consul.TLS.CAFile, "consul-ca", cfg.Storage.Consul.TLS.CAFile, "CA file the Consul certificate is verified against")
+	fs.StringVar(&cfg.Storage.Secrets.KEKFile, "kek-file", cfg.Storage.Secrets.KEKFile, "key-encryption key file")
+	fs.DurationVar(&cfg.Storage.Tombstones.Retention, "tombstone-retention", cfg.Storage.Tombstones.Retention, "how long deleted versions stay restorable")
+	fs.DurationVar(&cfg.Storage.Tombstones.PurgeInterval, "tombstone-purge-interval", cfg.Storage.Tombstones.PurgeInterval, "how often expired tombstones are purged")
+	fs.DurationVar(&cfg.Storage.InventoryInterval, "inventory-interval", cfg.Storage.InventoryInterval, "how often the inventory gauges are refreshed")
+
+	fs.StringVar(&cfg.Tracing.ServiceName, "service-name", cfg.Tracing.ServiceName, "service name reported to the tracing backend")
+	fs.StringVar(&cfg.Tracing.Exporter, "tracing-exporter", cfg.Tracing.Exporter, "otlp, jaeger or none")
+	fs.StringVar(&cfg.Tracing.Sampler.Type, "tracing-sampler", cfg.Tracing.Sampler.Type, "const, probabilistic or ratelimiting")
+	fs.Float64Var(&cfg.Tracing.Sampler.Param, "tracing-sampler-param", cfg.Tracing.Sampler.Param, "sampler parameter")
+
+	fs.BoolVar(&cfg.Metrics.Enabled, "metrics", cfg.Metrics.Enabled, "serve Prometheus metrics")
+	fs.StringVar(&cfg.Metrics.Path, "metrics-path", cfg.Metrics.Path, "path Prometheus metrics are served at")
+
+	fs.StringVar(&cfg.Logging.Level, "log-level", cfg.Logging.Level, "debug, info, warn or error")
+
+	return file
+}
+
+// storeFamilies are the first segments of the keys the store writes under its
+// prefix, as laid out in configstore/helper.go.
+var storeFamilies = []string{"configs", "groups", "ns", "tombstones", "audit", "policies", "idempotency", "namespaces"}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		fail("server.addr: %v", err)
+	}
+	for name, d := range map[string]time.Duration{
+		"server.read_header_timeout": c.Server.ReadHeaderTimeout,
+		"server.read_timeout":        c.Server.ReadTimeout,
+		"server.write_timeout":       c.Server.WriteTimeout,
+		"server.idle_timeout":        c.Server.IdleTimeout,
+		"server.drain_delay":         c.Server.DrainDelay,
+	} {
+		if d < 0 {
+			fail("%s: must not be negative", name)
+		}
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		fail("server.shutdown_timeout: must be positive")
+	}
+	if c.Server.MaxBodyBytes <= 0 {
+		fail("server.max_body_bytes: must be positive")
+	}
+	if c.Server.TLS.Enabled() {
+		if c.Server.TLS.CertFile == "" || c.Server.TLS.KeyFile == "" {
+			fail("server.tls: cert_file and key_file go together")
+		}
+		for _, f := range []string{c.Server.TLS.CertFile, c.Server.TLS.KeyFile, c.Server.TLS.ClientCAFile} {
+			if _, err := os.Stat(f); f != "" && err != nil {
+				fail("server.tls: %v", err)
+			}
+		}
+	}
+	switch c.Server.TLS.ClientAuth {
+	case "", ClientAuthNone:
+	case ClientAuthOptional, ClientAuthRequire:
+		if !c.Server.TLS.Enabled() {
+			fail("server.tls.client_auth: needs cert_file and key_file")
+		}
+		if c.Server.TLS.ClientCAFile == "" {
+			fail("server.tls.client_auth: needs client_ca_file")
+		}
+	default:
+		fail("server.tls.client_auth: unknown mode %q", c.Server.TLS.ClientAuth)
+	}
+	if c.Server.TLS.ReloadInterval < 0 {
+		fail("server.tls.reload_interval: must not be negative")
+	}
+
+	if c.Storage.Backend != BackendConsul {
+		fail("storage.backend: unknown backend %q, only %q is supported", c.Storage.Backend, BackendConsul)
+	}
+	if c.Storage.Consul.Address == "" {
+		fail("storage.consul.address: required")
+	}
+	if c.Storage.Consul.Token != "" && c.Storage.Consul.TokenFile != "" {
+		fail("storage.consul: token and token_file are mutually exclusive")
+	}
+	if prefix := c.Storage.Consul.Prefix; prefix != "" {
+		segments := strings.Split(strings.Trim(prefix, "/"), "/")
+		for _, segment := range segments {
+			if segment == "" || segment == "." || segment == ".." {
+				fail("storage.consul.prefix: invalid prefix %q", prefix)
+				break
+			}
+		}
+		if slices.Contains(storeFamilies, segments[0]) {
+			fail("storage.consul.prefix: %q starts with %s/, which a store without a prefix on the same cluster writes to", prefix, segments[0])
+		}
+	}
+	if (c.Storage.Consul.TLS.CertFile == "") != (c.Storage.Consul.TLS.KeyFile == "") {
+		fail("storage.consul.tls: cert_file and key_file go together")
+	}
+	if c.Storage.Tombstones.Retention <= 0 {
+		fail("storage.tombstones.retention: must be positive")
+	}
+	if c.Storage.Tombstones.PurgeInterval <= 0 {
+		fail("storage.tombstones.purge_interval: must be positive")
+	}
+	if c.Storage.InventoryInterval <= 0 {
+		fail("storage.inventory_interval: must be positive")
+	}
+
+	switch c.Tracing.Exporter {
+	case "", ExporterOTLP, ExporterJaeger, ExporterNone:
+	default:
+		fail("tracing.exporter: unknown exporter %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.ServiceName == "" {
+		fail("tracing.service_name: required")
+	}
+	switch c.Tracing.Sampler.Type {
+	case "", SamplerConst:
+	case SamplerProbabilistic:
+		if c.Tracing.Sampler.Param < 0 || c.Tracing.Sampler.Param > 1 {
+			fail("tracing.sampler.param: ratio %v is not between 0 and 1", c.Tracing.Sampler.Param)
+		}
+	case SamplerRateLimiting:
+		if c.Tracing.Sampler.Param < 0 {
+			fail("tracing.sampler.param: rate must not be negative")
+		}
+	default:
+		fail("tracing.sampler.type: unknown sampler %q", c.Tracing.Sampler.Type)
+	}
+	for route, ratio := range c.Tracing.Sampler.Routes {
+		if ratio < 0 || ratio > 1 {
+			fail("tracing.sampler.routes: ratio of %s must be between 0 and 1", route)
+		}
+	}
+
+	if c.Metrics.Enabled && !strings.HasPrefix(c.Metrics.Path, "/") {
+		fail("metrics.path: must start with /")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		fail("logging.level: %v", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// envReader sets fields from the variables that are set and not empty, and
+// collects the values it cannot parse.
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) lookup(name string) (string, bool) {
+	v := os.Getenv(name)
+	return v, v != ""
+}
+
+func (e *envReader) fail(name string, err error) {
+	e.errs = append(e.errs, fmt.Errorf("%s: %w", name, err))
+}
+
+func (e *envReader) string(name string, p *string) {
+	if v, ok := e.lookup(name); ok {
+		*p = v
+	}
+}
+
+func (e *envReader) list(name string, p *[]string) {
+	if v, ok := e.lookup(name); ok {
+		*p = strings.Split(v, ",")
+	}
+}
+
+func (e *envReader) duration(name string, p *time.Duration) {
+	if v, ok := e.lookup(name); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			e.fail(name, err)
+			return
+		}
+		*p = d
+	}
+}
+
+func (e *envReader) int64(name string, p *int64) {
+	if v, ok := e.lookup(name); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			e.fail(name, err)
+			return
+		}
+		*p = n
+	}
+}
+
+func (e *envReader) float(name string, p *float64) {
+	if v, ok := e.lookup(name); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			e.fail(name, err)
+			return
+		}
+		*p = f
+	}
+}
+
+func (e *envReader) bool(name string, p *bool) {
+	if v, ok := e.lookup(name); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			e.fail(name, err)
+			return
+		}
+		*p = b
+	}
+}
+
+// routes reads route=ratio pairs separated by commas. An empty variable
+// clears the defaults, unlike for the other settings.
+func (e *envReader) routes(name string, p *map[string]float64) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	rates := map[string]float64{}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, ratio, ok := strings.Cut(entry, "=")
+		if !ok {
+			e.fail(name, fmt.Errorf("expected route=ratio, got %q", entry))
+			return
+		}
+
+		r, err := strconv.ParseFloat(ratio, 64)
+		if err != nil {
+			e.fail(name, err)
+			return
+		}
+		rates[route] = r
+	}
+
+	*p = rates
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
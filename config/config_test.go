@@ -0,0 +1,162 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := `
+server:
+  addr: 0.0.0.0:7000
+  shutdown_timeout: 20s
+storage:
+  consul:
+    address: consul.file:8500
+    prefix: file/prefix
+`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"DB", "DBPORT", "CONFIG_FILE", "LISTEN_ADDR", "SHUTDOWN_TIMEOUT", "CONSUL_HTTP_ADDR", "CONSUL_KEY_PREFIX"} {
+		t.Setenv(name, "")
+	}
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		args        []string
+		wantAddr    string
+		wantConsul  string
+		wantPrefix  string
+		wantTimeout time.Duration
+	}{
+		{
+			name:        "file over defaults",
+			args:        []string{"-config", path},
+			wantAddr:    "0.0.0.0:7000",
+			wantConsul:  "consul.file:8500",
+			wantPrefix:  "file/prefix",
+			wantTimeout: 20 * time.Second,
+		},
+		{
+			name:        "environment over file",
+			env:         map[string]string{"CONFIG_FILE": path, "LISTEN_ADDR": "0.0.0.0:7001", "CONSUL_KEY_PREFIX": "env/prefix"},
+			wantAddr:    "0.0.0.0:7001",
+			wantConsul:  "consul.file:8500",
+			wantPrefix:  "env/prefix",
+			wantTimeout: 20 * time.Second,
+		},
+		{
+			name:        "flags over environment",
+			env:         map[string]string{"LISTEN_ADDR": "0.0.0.0:7001", "SHUTDOWN_TIMEOUT": "5s"},
+			args:        []string{"-config", path, "-addr", "0.0.0.0:7002", "-shutdown-timeout", "7s"},
+			wantAddr:    "0.0.0.0:7002",
+			wantConsul:  "consul.file:8500",
+			wantPrefix:  "file/prefix",
+			wantTimeout: 7 * time.Second,
+		},
+		{
+			name:        "DB and DBPORT over CONSUL_HTTP_ADDR",
+			env:         map[string]string{"CONSUL_HTTP_ADDR": "consul.env:8500", "DB": "consul", "DBPORT": "8501"},
+			wantAddr:    Default().Server.Addr,
+			wantConsul:  "consul:8501",
+			wantTimeout: Default().Server.ShutdownTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.Server.Addr != tt.wantAddr {
+				t.Errorf("server.addr = %q, want %q", cfg.Server.Addr, tt.wantAddr)
+			}
+			if cfg.Storage.Consul.Address != tt.wantConsul {
+				t.Errorf("storage.consul.address = %q, want %q", cfg.Storage.Consul.Address, tt.wantConsul)
+			}
+			if cfg.Storage.Consul.Prefix != tt.wantPrefix {
+				t.Errorf("storage.consul.prefix = %q, want %q", cfg.Storage.Consul.Prefix, tt.wantPrefix)
+			}
+			if cfg.Server.ShutdownTimeout != tt.wantTimeout {
+				t.Errorf("server.shutdown_timeout = %v, want %v", cfg.Server.ShutdownTimeout, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownFileKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("server:\n  adr: 0.0.0.0:7000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load([]string{"-config", path}); err == nil {
+		t.Error("Load() accepted a misspelt key")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{name: "defaults", change: func(c *Config) {}},
+		{name: "nested prefix", change: func(c *Config) { c.Storage.Consul.Prefix = "prod/config-service" }},
+		{name: "prefix sharing a word with a family", change: func(c *Config) { c.Storage.Consul.Prefix = "configs-prod/" }},
+		{name: "prefix with an empty segment", change: func(c *Config) { c.Storage.Consul.Prefix = "prod//config" }, wantErr: "storage.consul.prefix"},
+		{name: "prefix with a dot segment", change: func(c *Config) { c.Storage.Consul.Prefix = "prod/../config" }, wantErr: "storage.consul.prefix"},
+		{name: "prefix inside the configs family", change: func(c *Config) { c.Storage.Consul.Prefix = "configs/prod" }, wantErr: "starts with configs/"},
+		{name: "prefix inside the namespace data", change: func(c *Config) { c.Storage.Consul.Prefix = "/ns/" }, wantErr: "starts with ns/"},
+		{name: "prefix inside the audit log", change: func(c *Config) { c.Storage.Consul.Prefix = "audit" }, wantErr: "starts with audit/"},
+		{name: "no body limit", change: func(c *Config) { c.Server.MaxBodyBytes = 0 }, wantErr: "server.max_body_bytes"},
+		{name: "no consul address", change: func(c *Config) { c.Storage.Consul.Address = "" }, wantErr: "storage.consul.address"},
+		{name: "token and token file", change: func(c *Config) { c.Storage.Consul.Token, c.Storage.Consul.TokenFile = "t", "f" }, wantErr: "mutually exclusive"},
+		{name: "client auth without TLS", change: func(c *Config) { c.Server.TLS.ClientAuth = ClientAuthRequire }, wantErr: "server.tls.client_auth"},
+		{name: "probabilistic ratio above 1", change: func(c *Config) {
+			c.Tracing.Sampler.Type, c.Tracing.Sampler.Param = SamplerProbabilistic, 2
+		}, wantErr: "tracing.sampler.param"},
+		{name: "route ratio below 0", change: func(c *Config) { c.Tracing.Sampler.Routes = map[string]float64{"/healthz": -1} }, wantErr: "tracing.sampler.routes"},
+		{name: "metrics path", change: func(c *Config) { c.Metrics.Path = "metrics" }, wantErr: "metrics.path"},
+		{name: "log level", change: func(c *Config) { c.Logging.Level = "loud" }, wantErr: "logging.level"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(cfg)
+
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate() error = %v, want one about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEverySetting(t *testing.T) {
+	cfg := Default()
+	cfg.Storage.Consul.Address = ""
+	cfg.Logging.Level = "loud"
+
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "storage.consul.address") || !strings.Contains(err.Error(), "logging.level") {
+		t.Errorf("Validate() error = %v, want both invalid settings", err)
+	}
+}
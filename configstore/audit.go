@@ -0,0 +1,262 @@
+package poststore
+
+import (
+	"ars-projekat/auth"
+	model "ars-projekat/model"
+	tracer "ars-projekat/tracer"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recordAudit appends an entry for a mutation of resource to the audit log.
+// before and after are the pairs under resource around the mutation, of which
+// only a hash is kept. Entries are written with a check-and-set on index 0, so
+// an existing entry can never be overwritten. A failure is logged on the span
+// and does not undo the mutation that already happened.
+func (ps *ConfigStore) recordAudit(ctx context.Context, action string, resource string, before api.KVPairs, after api.KVPairs) {
+	span := tracer.StartSpanFromContext(ctx, "recordAudit")
+	defer span.Finish()
+
+	// The change being recorded has already happened, so the entry is written
+	// even if the caller has gone away in the meantime.
+	kv := ps.rootKV(context.WithoutCancel(ctx))
+
+	// The log is shared, so entries for a namespace name it in the resource.
+	resource = namespacePrefix(NamespaceFromContext(ctx)) + resource
+
+	now := time.Now().UTC()
+
+	entry := model.AuditJSON{
+		Time:      now,
+		Actor:     "unknown",
+		RequestId: model.RequestIDFromContext(ctx),
+		TraceId:   tracer.TraceID(ctx),
+		Action:    action,
+		Resource:  resource,
+		Before:    hashPairs(before),
+		After:     hashPairs(after),
+	}
+	if identity, ok := auth.IdentityFromContext(ctx); ok {
+		entry.Actor = identity.Subject
+	}
+
+	// A taken key means another entry got the same time and id; a fresh id
+	// makes the next attempt succeed.
+	err := errors.New("audit key taken")
+	for attempt := 0; attempt < auditAttempts && err != nil; attempt++ {
+		var key string
+		key, entry.Id = generateAuditKey(now)
+
+		var data []byte
+		data, err = json.Marshal(entry)
+		if err != nil {
+			break
+		}
+
+		casSpan := tracer.StartSpanFromContext(ctx, "CAS")
+		var ok bool
+		ok, _, err = kv.CAS(&api.KVPair{Key: key, Value: data}, nil)
+		casSpan.Finish()
+
+		if err == nil && !ok {
+			err = errors.New("audit key " + key + " taken")
+		}
+	}
+
+	if err != nil {
+		tracer.LogError(span, err)
+		ps.logger.ErrorContext(ctx, "audit entry not written",
+			slog.String("action", action),
+			slog.String("resource", resource),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// ListAudit returns up to filter.Limit matching entries, oldest first, and the
+// cursor to pass as filter.After for the next page, which is empty on the
+// last one. Only keys are listed for the whole log: Since, Until and After are
+// applied to the time in the key, and entries are then read in batches until
+// the page is full.
+func (ps *ConfigStore) ListAudit(ctx context.Context, filter *model.AuditFilter) ([]*model.AuditJSON, string, error) {
+	span := tracer.StartSpanFromContext(ctx, "ListAudit")
+	defer span.Finish()
+
+	kv := ps.rootKV(ctx)
+
+	keysSpan := tracer.StartSpanFromContext(ctx, "Keys")
+	keys, _, err := kv.Keys(auditKeyPrefix(filter), "", nil)
+	keysSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, "", err
+	}
+
+	keys = auditKeysInRange(keys, filter)
+
+	entries := []*model.AuditJSON{}
+	for start := 0; start < len(keys); start += auditBatch {
+		batch := keys[start:min(start+auditBatch, len(keys))]
+
+		ops := make(api.KVTxnOps, 0, len(batch))
+		for _, key := range batch {
+			ops = append(ops, &api.KVTxnOp{Verb: api.KVGet, Key: key})
+		}
+
+		txnSpan := tracer.StartSpanFromContext(ctx, "Txn")
+		ok, resp, _, err := kv.Txn(ops, nil)
+		txnSpan.Finish()
+
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, "", err
+		}
+		if !ok {
+			// entries are never removed, so this only happens when they were
+			// removed by hand
+			err := errors.New("audit entries removed while being read")
+			tracer.LogError(span, err)
+			return nil, "", err
+		}
+
+		for i, pair := range resp.Results {
+			entry := &model.AuditJSON{}
+			if err := json.Unmarshal(pair.Value, entry); err != nil {
+				return nil, "", err
+			}
+
+			if !auditMatches(entry, filter) {
+				continue
+			}
+			entries = append(entries, entry)
+
+			if len(entries) == filter.Limit {
+				if start+i+1 < len(keys) {
+					return entries, auditCursor(batch[i]), nil
+				}
+				return entries, "", nil
+			}
+		}
+	}
+
+	return entries, "", nil
+}
+
+const (
+	// auditAttempts bounds the tries to find a free key for an entry.
+	auditAttempts = 3
+	// auditBatch is how many entries a single transaction reads, within the
+	// number of operations Consul allows per transaction.
+	auditBatch = 64
+)
+
+// auditKeyPrefix narrows the keys to list to those sharing the leading digits
+// of both Since and Until, when both are set.
+func auditKeyPrefix(filter *model.AuditFilter) string {
+	if filter.Since.IsZero() || filter.Until.IsZero() {
+		return auditPrefix
+	}
+
+	lower, upper := auditTime(filter.Since), auditTime(filter.Until)
+	n := 0
+	for n < len(lower) && lower[n] == upper[n] {
+		n++
+	}
+
+	return auditPrefix + lower[:n]
+}
+
+// auditKeysInRange keeps the keys of entries written in [Since, Until) and
+// after the cursor After. Keys start with the zero padded time, so they
+// compare as strings.
+func auditKeysInRange(keys []string, filter *model.AuditFilter) []string {
+	since, until := "", ""
+	if !filter.Since.IsZero() {
+		since = auditTime(filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		until = auditTime(filter.Until)
+	}
+
+	sort.Strings(keys)
+
+	inRange := keys[:0]
+	for _, key := range keys {
+		cursor := auditCursor(key)
+		if since != "" && cursor < since {
+			continue
+		}
+		if until != "" && cursor >= until {
+			continue
+		}
+		if filter.After != "" && cursor <= filter.After {
+			continue
+		}
+		inRange = append(inRange, key)
+	}
+
+	return inRange
+}
+
+func auditTime(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// auditCursor is the part of an audit key that identifies the entry.
+func auditCursor(key string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(key, auditPrefix), "/")
+}
+
+func auditMatches(entry *model.AuditJSON, filter *model.AuditFilter) bool {
+	if filter.Actor != "" && entry.Actor != filter.Actor {
+		return false
+	}
+	if filter.Action != "" && entry.Action != filter.Action {
+		return false
+	}
+	if filter.Resource != "" && !strings.HasPrefix(entry.Resource, filter.Resource) {
+		return false
+	}
+	if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !entry.Time.Before(filter.Until) {
+		return false
+	}
+
+	return true
+}
+
+// hashPairs digests keys and values of pairs independent of their order. An
+// empty set hashes to the empty string, marking a resource that did not exist.
+func hashPairs(pairs api.KVPairs) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	sorted := make(api.KVPairs, len(pairs))
+	copy(sorted, pairs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	h := sha256.New()
+	for _, p := range sorted {
+		h.Write([]byte(p.Key))
+		h.Write([]byte{0})
+		h.Write(p.Value)
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,77 @@
+package poststore
+
+import (
+	"ars-projekat/model"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestListAuditPages(t *testing.T) {
+	ps, _ := newTestStore(t)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 150; i++ {
+		action := model.AuditCreate
+		if i%3 == 0 {
+			action = model.AuditDelete
+		}
+		ps.recordAudit(ctx, action, fmt.Sprintf("configs/%03d/1/", i), nil, nil)
+	}
+	end := time.Now()
+
+	tests := []struct {
+		name   string
+		filter model.AuditFilter
+		want   int
+	}{
+		{name: "everything", filter: model.AuditFilter{Limit: 40}, want: 150},
+		{name: "one page", filter: model.AuditFilter{Limit: 1000}, want: 150},
+		{name: "filtered across batches", filter: model.AuditFilter{Action: model.AuditDelete, Limit: 7}, want: 50},
+		{name: "time range", filter: model.AuditFilter{Since: start, Until: end, Limit: 100}, want: 150},
+		{name: "before every entry", filter: model.AuditFilter{Until: start, Limit: 100}, want: 0},
+		{name: "without limit", filter: model.AuditFilter{}, want: 150},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := tt.filter
+			seen := map[string]bool{}
+			last := ""
+			for pages := 0; ; pages++ {
+				if pages > 200 {
+					t.Fatal("pagination does not end")
+				}
+
+				entries, next, err := ps.ListAudit(ctx, &filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if filter.Limit > 0 && len(entries) > filter.Limit {
+					t.Fatalf("page of %d entries, limit %d", len(entries), filter.Limit)
+				}
+				for _, entry := range entries {
+					if seen[entry.Id] {
+						t.Fatalf("entry %s on two pages", entry.Id)
+					}
+					seen[entry.Id] = true
+					if entry.Resource < last {
+						t.Fatalf("entry %s out of order", entry.Resource)
+					}
+					last = entry.Resource
+				}
+
+				if next == "" {
+					break
+				}
+				filter.After = next
+			}
+
+			if len(seen) != tt.want {
+				t.Errorf("listed %d entries, want %d", len(seen), tt.want)
+			}
+		})
+	}
+}
@@ -1,33 +1,82 @@
 package poststore
 
 import (
+	"ars-projekat/config"
 	model "ars-projekat/model"
+	"ars-projekat/secrets"
 	tracer "ars-projekat/tracer"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/hashicorp/consul/api"
-	"os"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrConfigNotFound = errors.New("Config not found")
+	ErrGroupNotFound  = errors.New("Group not found")
+	ErrConflict       = errors.New("Changed concurrently, try again")
 )
 
 type ConfigStore struct {
-	cli *api.Client
+	cli      *api.Client
+	root     string
+	logger   *slog.Logger
+	keyring  *secrets.Keyring
+	rotation *rotation
+	// retention is how long deleted versions stay restorable, purgeInterval
+	// how often expired ones are removed for good.
+	retention     time.Duration
+	purgeInterval time.Duration
+	// inventoryInterval is how often the inventory gauges are refreshed.
+	inventoryInterval time.Duration
 }
 
-func New() (*ConfigStore, error) {
-	db := os.Getenv("DB")
-	dbport := os.Getenv("DBPORT")
-
-	config := api.DefaultConfig()
-	config.Address = fmt.Sprintf("%s:%s", db, dbport)
-	client, err := api.NewClient(config)
+func New(cfg config.StorageConfig, logger *slog.Logger) (*ConfigStore, error) {
+	consul := api.DefaultConfig()
+	consul.Address = cfg.Consul.Address
+	consul.Token = cfg.Consul.Token
+	consul.TokenFile = cfg.Consul.TokenFile
+	consul.Datacenter = cfg.Consul.Datacenter
+	if cfg.Consul.TLS.Enabled {
+		consul.Scheme = "https"
+		consul.TLSConfig = api.TLSConfig{
+			Address:            cfg.Consul.TLS.ServerName,
+			CAFile:             cfg.Consul.TLS.CAFile,
+			CertFile:           cfg.Consul.TLS.CertFile,
+			KeyFile:            cfg.Consul.TLS.KeyFile,
+			InsecureSkipVerify: cfg.Consul.TLS.InsecureSkipVerify,
+		}
+	} else {
+		// DefaultConfig reads the CONSUL_* variables as well, but cfg has
+		// already taken them into account.
+		consul.Scheme = "http"
+		consul.TLSConfig = api.TLSConfig{}
+	}
+	client, err := api.NewClient(consul)
 	if err != nil {
 		return nil, err
 	}
 
+	var keyring *secrets.Keyring
+	if cfg.Secrets.KEKFile != "" {
+		keyring, err = secrets.LoadKeyring(cfg.Secrets.KEKFile, cfg.Secrets.KEKPreviousFiles...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &ConfigStore{
-		cli: client,
+		cli:               client,
+		root:              rootPrefix(cfg.Consul.Prefix),
+		logger:            logger,
+		keyring:           keyring,
+		rotation:          &rotation{},
+		retention:         cfg.Tombstones.Retention,
+		purgeInterval:     cfg.Tombstones.PurgeInterval,
+		inventoryInterval: cfg.InventoryInterval,
 	}, nil
 }
 
@@ -35,7 +84,7 @@ func (ps *ConfigStore) IdempotencyKeyExists(ctx context.Context, key string) (bo
 	span := tracer.StartSpanFromContext(ctx, "IdempotencyKeyExists")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
 	idempotencyKey := fmt.Sprintf("idempotency/%s/", key)
 
@@ -55,55 +104,48 @@ func (ps *ConfigStore) CreateConfig(ctx context.Context, configJSON *model.Confi
 	span := tracer.StartSpanFromContext(ctx, "CreateConfig")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
-
 	sid, rid := generateConfigKey(configJSON.Version)
-	config := model.Config{
-		Key:   configJSON.Key,
-		Value: configJSON.Value,
-	}
-
-	data, err := json.Marshal(config)
+	data, err := ps.encodeConfig(ctx, sid, configJSON.Key, configJSON.Value, configJSON.Secret)
 	if err != nil {
 		return "", err
 	}
 
 	p := &api.KVPair{Key: sid, Value: data}
 
-	putSpan := tracer.StartSpanFromContext(ctx, "Put")
-	_, err = kv.Put(p, nil)
-	putSpan.Finish()
-
-	if err != nil {
+	if err := ps.createWithinQuota(ctx, model.RecordConfig, p); err != nil {
+		tracer.LogError(span, err)
 		return "", err
 	}
 
+	ps.recordAudit(ctx, model.AuditCreate, sid, nil, api.KVPairs{p})
+
 	return rid, nil
 }
 
 func (ps *ConfigStore) CreateConfigVersion(ctx context.Context, id string, configJSON *model.ConfigJSON) (string, error) {
 	span := tracer.StartSpanFromContext(ctx, "CreateConfigVersion")
 	defer span.Finish()
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
-	confExists := ps.CheckIfConfigExists(ctx, id)
+	confExists, err := ps.CheckIfConfigExists(ctx, id)
+	if err != nil {
+		return "", err
+	}
 	if !confExists {
-		return "", errors.New("Config not found")
+		return "", ErrConfigNotFound
 	}
 
-	confVersionExists := ps.CheckIfConfigVersionExists(ctx, id, configJSON.Version)
+	confVersionExists, err := ps.CheckIfConfigVersionExists(ctx, id, configJSON.Version)
+	if err != nil {
+		return "", err
+	}
 	if confVersionExists {
 		return "", errors.New("Config version already exists")
 	}
 
 	configKey := constructConfigKey(id, configJSON.Version)
 
-	config := model.Config{
-		Key:   configJSON.Key,
-		Value: configJSON.Value,
-	}
-
-	data, err := json.Marshal(config)
+	data, err := ps.encodeConfig(ctx, configKey, configJSON.Key, configJSON.Value, configJSON.Secret)
 	if err != nil {
 		return "", err
 	}
@@ -118,6 +160,8 @@ func (ps *ConfigStore) CreateConfigVersion(ctx context.Context, id string, confi
 		return "", err
 	}
 
+	ps.recordAudit(ctx, model.AuditVersion, configKey, nil, api.KVPairs{p})
+
 	return configKey, nil
 }
 
@@ -125,43 +169,48 @@ func (ps *ConfigStore) CreateGroup(ctx context.Context, groupJSON *model.GroupJS
 	span := tracer.StartSpanFromContext(ctx, "CreateGroup")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
 	groupId := createId()
 
-	for _, c := range groupJSON.Configs {
+	written := api.KVPairs{}
+	for i, c := range groupJSON.Configs {
 		labels := model.DecodeJSONLabels(ctx, c.Labels)
 		groupConfigKey, _ := generateGroupConfigKey(groupId, groupJSON.Version, labels)
 
-		config := model.Config{
-			Key:   c.Key,
-			Value: c.Value,
-		}
-
-		data, err := json.Marshal(config)
+		data, err := ps.encodeConfig(ctx, groupConfigKey, c.Key, c.Value, c.Secret)
 		if err != nil {
 			return "", err
 		}
 
 		p := &api.KVPair{Key: groupConfigKey, Value: data}
 
-		putSpan := tracer.StartSpanFromContext(ctx, "Put")
-		_, err = kv.Put(p, nil)
-		putSpan.Finish()
+		// the first config creates the group, counted against the quota
+		if i == 0 {
+			err = ps.createWithinQuota(ctx, model.RecordGroup, p)
+		} else {
+			putSpan := tracer.StartSpanFromContext(ctx, "Put")
+			_, err = kv.Put(p, nil)
+			putSpan.Finish()
+		}
 
 		if err != nil {
+			tracer.LogError(span, err)
 			return "", err
 		}
+		written = append(written, p)
 	}
 
+	ps.recordAudit(ctx, model.AuditCreate, constructGroupKey(groupId, groupJSON.Version, ""), nil, written)
+
 	return groupId, nil
 }
 
-func (ps *ConfigStore) GetConfig(ctx context.Context, id string, version string) (*model.Config, error) {
+func (ps *ConfigStore) GetConfig(ctx context.Context, id string, version string) (*model.Config, *model.Meta, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetConfig")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
 	configKey := constructConfigKey(id, version)
 
@@ -171,27 +220,26 @@ func (ps *ConfigStore) GetConfig(ctx context.Context, id string, version string)
 
 	if err != nil {
 		tracer.LogError(span, err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	if pair == nil {
-		return nil, errors.New("Config not found")
+		return nil, nil, ErrConfigNotFound
 	}
 
-	post := &model.Config{}
-	err = json.Unmarshal(pair.Value, post)
+	post, err := ps.decodeConfig(ctx, pair.Key, pair.Value)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return post, nil
+	return post, pairMeta(pair), nil
 }
 
-func (ps *ConfigStore) GetGroup(ctx context.Context, id string, version string, labels string) ([]*model.Config, error) {
+func (ps *ConfigStore) GetGroup(ctx context.Context, id string, version string, labels string) ([]*model.Config, *model.Meta, error) {
 	span := tracer.StartSpanFromContext(ctx, "GetGroup")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
 	groupKey := constructGroupKey(id, version, labels)
 
@@ -201,72 +249,110 @@ func (ps *ConfigStore) GetGroup(ctx context.Context, id string, version string,
 
 	if err != nil {
 		tracer.LogError(span, err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	if data == nil {
-		return nil, errors.New("Group not found")
+		return nil, nil, ErrGroupNotFound
 	}
 
+	meta := &model.Meta{}
 	groupConfigs := []*model.Config{}
 	for _, pair := range data {
-		config := &model.Config{}
-		err = json.Unmarshal(pair.Value, config)
+		meta.Merge(pairMeta(pair))
+		config, err := ps.decodeConfig(ctx, pair.Key, pair.Value)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		groupConfigs = append(groupConfigs, config)
 	}
 
-	return groupConfigs, nil
+	return groupConfigs, meta, nil
 }
 
-func (ps *ConfigStore) DeleteConfig(ctx context.Context, id string, version string) (map[string]string, error) {
+func (ps *ConfigStore) DeleteConfig(ctx context.Context, id string, version string) (*model.DeletedJSON, error) {
 	span := tracer.StartSpanFromContext(ctx, "DeleteConfig")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
 	configKey := constructConfigKey(id, version)
 
-	deleteSpan := tracer.StartSpanFromContext(ctx, "Delete")
-	_, err := kv.Delete(configKey, nil)
-	deleteSpan.Finish()
+	getSpan := tracer.StartSpanFromContext(ctx, "Get")
+	before, _, err := kv.Get(configKey, nil)
+	getSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if before == nil {
+		return nil, ErrConfigNotFound
+	}
+
+	t, err := tombstonePair(ctx, configKey, pairsOf(before))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// The tombstone and the delete go in one transaction, and the delete only
+	// applies to the version that was read, so a concurrent delete leaves
+	// nothing behind and is reported as not found.
+	ops := api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVCAS, Key: t.Key, Value: t.Value, Flags: t.Flags, Index: 0},
+		&api.KVTxnOp{Verb: api.KVDeleteCAS, Key: configKey, Index: before.ModifyIndex},
+	}
+
+	ok, _, err := ps.commit(ctx, ops)
 
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	return map[string]string{"Deleted": id}, nil
+	if !ok {
+		return nil, ErrConfigNotFound
+	}
+
+	ps.recordAudit(ctx, model.AuditDelete, configKey, pairsOf(before), nil)
+
+	return &model.DeletedJSON{Deleted: id}, nil
 }
 
 func (ps *ConfigStore) AddConfigToGroup(ctx context.Context, id string, version string, groupConfigJSON *model.GroupConfigJSON) (string, error) {
 	span := tracer.StartSpanFromContext(ctx, "AddConfigToGroup")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
-	verExists := ps.CheckIfGroupVersionExists(ctx, id, version)
+	verExists, err := ps.CheckIfGroupVersionExists(ctx, id, version)
+	if err != nil {
+		return "", err
+	}
 	if !verExists {
-		return "", errors.New("Group not found")
+		return "", ErrGroupNotFound
 	}
 
 	labels := model.DecodeJSONLabels(ctx, groupConfigJSON.Labels)
 	groupConfigKey := constructGroupKey(id, version, labels)
 
-	config := model.Config{
-		Key:   groupConfigJSON.Key,
-		Value: groupConfigJSON.Value,
-	}
-
-	data, err := json.Marshal(config)
+	data, err := ps.encodeConfig(ctx, groupConfigKey, groupConfigJSON.Key, groupConfigJSON.Value, groupConfigJSON.Secret)
 	if err != nil {
 		return "", err
 	}
 
 	p := &api.KVPair{Key: groupConfigKey, Value: data}
 
+	getSpan := tracer.StartSpanFromContext(ctx, "Get")
+	before, _, err := kv.Get(groupConfigKey, nil)
+	getSpan.Finish()
+
+	if err != nil {
+		return "", err
+	}
+
 	putSpan := tracer.StartSpanFromContext(ctx, "Put")
 	_, err = kv.Put(p, nil)
 	putSpan.Finish()
@@ -275,15 +361,17 @@ func (ps *ConfigStore) AddConfigToGroup(ctx context.Context, id string, version
 		return "", err
 	}
 
+	ps.recordAudit(ctx, model.AuditAddToGroup, groupConfigKey, pairsOf(before), api.KVPairs{p})
+
 	return groupConfigKey, nil
 
 }
 
-func (ps *ConfigStore) CheckIfConfigExists(ctx context.Context, id string) bool {
+func (ps *ConfigStore) CheckIfConfigExists(ctx context.Context, id string) (bool, error) {
 	span := tracer.StartSpanFromContext(ctx, "CheckIfConfigExists")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
 	groupKey := fmt.Sprintf("configs/%s/", id)
 
@@ -292,21 +380,22 @@ func (ps *ConfigStore) CheckIfConfigExists(ctx context.Context, id string) bool
 	listSpan.Finish()
 
 	if err != nil {
-		return false
+		tracer.LogError(span, err)
+		return false, err
 	}
 
 	if data == nil {
-		return false
+		return false, nil
 	}
 
-	return true
+	return true, nil
 }
 
-func (ps *ConfigStore) CheckIfGroupVersionExists(ctx context.Context, id string, version string) bool {
+func (ps *ConfigStore) CheckIfGroupVersionExists(ctx context.Context, id string, version string) (bool, error) {
 	span := tracer.StartSpanFromContext(ctx, "CheckIfGroupVersionExists")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
 	groupKey := fmt.Sprintf("groups/%s/%s/", id, version)
 
@@ -316,42 +405,44 @@ func (ps *ConfigStore) CheckIfGroupVersionExists(ctx context.Context, id string,
 
 	if err != nil {
 		tracer.LogError(span, err)
-		return false
+		return false, err
 	}
 
 	if data == nil {
-		return false
+		return false, nil
 	}
 
-	return true
+	return true, nil
 }
 
 func (ps *ConfigStore) CreateGroupVersion(ctx context.Context, groupId string, groupJSON *model.GroupJSON) (string, error) {
 	span := tracer.StartSpanFromContext(ctx, "CreateGroupVersion")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
-	groupExists := ps.CheckIfGroupExists(groupId)
+	groupExists, err := ps.CheckIfGroupExists(ctx, groupId)
+	if err != nil {
+		return "", err
+	}
 	if !groupExists {
-		return "", errors.New("Group not found")
+		return "", ErrGroupNotFound
 	}
 
-	groupVersionExists := ps.CheckIfGroupVersionExists(ctx, groupId, groupJSON.Version)
+	groupVersionExists, err := ps.CheckIfGroupVersionExists(ctx, groupId, groupJSON.Version)
+	if err != nil {
+		return "", err
+	}
 	if groupVersionExists {
 		return "", errors.New("Group version already exists")
 	}
 
+	written := api.KVPairs{}
 	for _, c := range groupJSON.Configs {
 		labels := model.DecodeJSONLabels(ctx, c.Labels)
 		groupConfigKey, _ := generateGroupConfigKey(groupId, groupJSON.Version, labels)
 
-		config := model.Config{
-			Key:   c.Key,
-			Value: c.Value,
-		}
-
-		data, err := json.Marshal(config)
+		data, err := ps.encodeConfig(ctx, groupConfigKey, c.Key, c.Value, c.Secret)
 		if err != nil {
 			return "", err
 		}
@@ -365,53 +456,104 @@ func (ps *ConfigStore) CreateGroupVersion(ctx context.Context, groupId string, g
 		if err != nil {
 			return "", err
 		}
+		written = append(written, p)
 	}
 
+	ps.recordAudit(ctx, model.AuditVersion, constructGroupKey(groupId, groupJSON.Version, ""), nil, written)
+
 	return groupId, nil
 }
 
-func (ps *ConfigStore) CheckIfGroupExists(id string) bool {
-	kv := ps.cli.KV()
+func (ps *ConfigStore) CheckIfGroupExists(ctx context.Context, id string) (bool, error) {
+	span := tracer.StartSpanFromContext(ctx, "CheckIfGroupExists")
+	defer span.Finish()
+
+	kv := ps.kv(ctx)
 
 	groupKey := fmt.Sprintf("groups/%s/", id)
 
+	listSpan := tracer.StartSpanFromContext(ctx, "List")
 	data, _, err := kv.List(groupKey, nil)
+	listSpan.Finish()
+
 	if err != nil {
-		return false
+		tracer.LogError(span, err)
+		return false, err
 	}
 
 	if data == nil {
-		return false
+		return false, nil
 	}
 
-	return true
+	return true, nil
 }
 
-func (ps *ConfigStore) DeleteGroup(ctx context.Context, id string, version string) (map[string]string, error) {
+func (ps *ConfigStore) DeleteGroup(ctx context.Context, id string, version string) (*model.DeletedJSON, error) {
 	span := tracer.StartSpanFromContext(ctx, "DeleteGroup")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
 	groupKey := constructGroupKey(id, version, "")
 
-	deleteTreeSpan := tracer.StartSpanFromContext(ctx, "DeleteTree")
-	_, err := kv.DeleteTree(groupKey, nil)
-	deleteTreeSpan.Finish()
+	listSpan := tracer.StartSpanFromContext(ctx, "List")
+	before, _, err := kv.List(groupKey, nil)
+	listSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if len(before) == 0 {
+		return nil, ErrGroupNotFound
+	}
 
+	t, err := tombstonePair(ctx, groupKey, before)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	return map[string]string{"Deleted": id}, nil
+	// As in DeleteConfig, the tombstone goes ahead of the deletes, which only
+	// remove the pairs that were listed. A version can hold more configs than
+	// fit in one transaction, so they are committed in batches, the first one
+	// with the tombstone: of two concurrent deletes the second is reported as
+	// not found, and a change after the first batch stops the delete with
+	// ErrConflict.
+	ops := api.KVTxnOps{&api.KVTxnOp{Verb: api.KVCAS, Key: t.Key, Value: t.Value, Flags: t.Flags, Index: 0}}
+	for _, pair := range before {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVDeleteCAS, Key: pair.Key, Index: pair.ModifyIndex})
+	}
+
+	committed, ok, _, err := ps.commitBatches(ctx, ops)
+
+	// the pairs removed before a failed batch are gone all the same
+	if committed > 1 {
+		ps.recordAudit(ctx, model.AuditDelete, groupKey, before[:committed-1], nil)
+	}
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if !ok && committed == 0 {
+		return nil, ErrGroupNotFound
+	}
+
+	if !ok {
+		return nil, ErrConflict
+	}
+
+	return &model.DeletedJSON{Deleted: id, Configs: len(before)}, nil
 }
 
-func (ps *ConfigStore) CheckIfConfigVersionExists(ctx context.Context, id string, version string) bool {
+func (ps *ConfigStore) CheckIfConfigVersionExists(ctx context.Context, id string, version string) (bool, error) {
 	span := tracer.StartSpanFromContext(ctx, "CheckConfigVersion")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	kv := ps.kv(ctx)
 
 	groupKey := fmt.Sprintf("configs/%s/%s/", id, version)
 
@@ -421,25 +563,82 @@ func (ps *ConfigStore) CheckIfConfigVersionExists(ctx context.Context, id string
 
 	if err != nil {
 		tracer.LogError(span, err)
-		return false
+		return false, err
 	}
 
 	if data == nil {
-		tracer.LogError(span, err)
-		return false
+		return false, nil
 	}
 
-	return true
+	return true, nil
 }
 
 func (ps *ConfigStore) SaveIdempotencyKey(ctx context.Context, key string, itemId string) {
 	span := tracer.StartSpanFromContext(ctx, "SaveIdempotencyKey")
 	defer span.Finish()
 
-	kv := ps.cli.KV()
+	// Saved regardless of the caller, so a retry finds the item it created.
+	kv := ps.kv(context.WithoutCancel(ctx))
 
 	idempotencyKey := constructIdempotencyKey(key)
 
 	p := &api.KVPair{Key: idempotencyKey, Value: []byte(itemId)}
 	kv.Put(p, nil)
 }
+
+// GroupLabelSets returns the distinct label sets of the configs of a group
+// version, or of every version when version is empty. Deleted versions that
+// can still be read or restored count as well.
+func (ps *ConfigStore) GroupLabelSets(ctx context.Context, id string, version string) ([]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "GroupLabelSets")
+	defer span.Finish()
+
+	kv := ps.kv(ctx)
+
+	prefix := fmt.Sprintf("%s%s/", groupsPrefix, id)
+	if version != "" {
+		prefix = constructGroupKey(id, version, "")
+	}
+
+	keysSpan := tracer.StartSpanFromContext(ctx, "Keys")
+	keys, _, err := kv.Keys(prefix, "", nil)
+	keysSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	listSpan := tracer.StartSpanFromContext(ctx, "List")
+	tombstones, _, err := kv.List(constructTombstoneKey(prefix), nil)
+	listSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	for _, pair := range tombstones {
+		t := &model.TombstoneJSON{}
+		if err := json.Unmarshal(pair.Value, t); err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		for _, p := range t.Pairs {
+			keys = append(keys, p.Key)
+		}
+	}
+
+	seen := map[string]bool{}
+	sets := []string{}
+	for _, key := range keys {
+		_, _, labels, _, ok := parseGroupKey(key)
+		if !ok || seen[labels] {
+			continue
+		}
+		seen[labels] = true
+		sets = append(sets, labels)
+	}
+
+	return sets, nil
+}
@@ -0,0 +1,54 @@
+package poststore
+
+import (
+	"ars-projekat/model"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExistenceChecksReportErrors(t *testing.T) {
+	ps, _ := newTestStore(t)
+
+	id, err := ps.CreateConfig(context.Background(), &model.ConfigJSON{Key: "k", Value: "v", Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := ps.CheckIfConfigExists(ctx, id); !errors.Is(err, context.Canceled) {
+		t.Errorf("CheckIfConfigExists() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := ps.CreateConfigVersion(ctx, id, &model.ConfigJSON{Key: "k", Value: "v", Version: "2"}); errors.Is(err, ErrConfigNotFound) || !errors.Is(err, context.Canceled) {
+		t.Errorf("CreateConfigVersion() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestConfigModifiedTime(t *testing.T) {
+	ps, fake := newTestStore(t)
+	ctx := context.Background()
+
+	before := time.Now().Add(-time.Second)
+	id, err := ps.CreateConfig(ctx, &model.ConfigJSON{Key: "port", Value: "8080", Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if flags := fake.pairs[constructConfigKey(id, "1")].Flags; flags != 0 {
+		t.Errorf("stored Flags = %d, want them left alone", flags)
+	}
+
+	config, meta, err := ps.GetConfig(ctx, id, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Modified != nil {
+		t.Errorf("GetConfig() = %+v, want the modification time kept out of the config", config)
+	}
+	if meta.Modified.Before(before) || meta.Modified.After(time.Now()) {
+		t.Errorf("GetConfig() modified = %v, want the write time", meta.Modified)
+	}
+}
@@ -0,0 +1,283 @@
+package poststore
+
+import (
+	"ars-projekat/config"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeConsul serves the parts of the Consul KV and transaction API the store
+// uses, from memory.
+type fakeConsul struct {
+	mu    sync.Mutex
+	index uint64
+	pairs map[string]*fakePair
+	// beforeTxn, when set, runs once ahead of the next transaction, to
+	// simulate a concurrent write.
+	beforeTxn func(f *fakeConsul)
+}
+
+type fakePair struct {
+	Key         string
+	CreateIndex uint64
+	ModifyIndex uint64
+	Flags       uint64
+	Value       []byte
+}
+
+type fakeTxnOp struct {
+	KV *struct {
+		Verb  string
+		Key   string
+		Value []byte
+		Flags uint64
+		Index uint64
+	}
+}
+
+// newTestStore returns a store backed by a fresh fakeConsul.
+func newTestStore(t *testing.T) (*ConfigStore, *fakeConsul) {
+	t.Helper()
+
+	fake := &fakeConsul{pairs: map[string]*fakePair{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	cfg := config.Default().Storage
+	cfg.Consul.Address = strings.TrimPrefix(srv.URL, "http://")
+
+	ps, err := New(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ps, fake
+}
+
+// keys returns the stored keys under prefix, sorted.
+func (f *fakeConsul) keys(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := []string{}
+	for k := range f.pairs {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	w.Header().Set("X-Consul-KnownLeader", "true")
+
+	switch {
+	case r.URL.Path == "/v1/status/leader":
+		json.NewEncoder(w).Encode("127.0.0.1:8300")
+	case r.URL.Path == "/v1/txn" && r.Method == http.MethodPut:
+		f.txn(w, r)
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		f.kv(w, r, strings.TrimPrefix(r.URL.Path, "/v1/kv/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeConsul) kv(w http.ResponseWriter, r *http.Request, key string) {
+	q := r.URL.Query()
+	_, recurse := q["recurse"]
+
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := q["keys"]; ok {
+			keys := f.listKeys(key, q.Get("separator"))
+			if len(keys) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(keys)
+			return
+		}
+
+		var pairs []*fakePair
+		if recurse {
+			for _, k := range f.listKeys(key, "") {
+				pairs = append(pairs, f.pairs[k])
+			}
+		} else if p, ok := f.pairs[key]; ok {
+			pairs = append(pairs, p)
+		}
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(pairs)
+	case http.MethodPut:
+		value, _ := io.ReadAll(r.Body)
+		flags, _ := strconv.ParseUint(q.Get("flags"), 10, 64)
+		if c := q.Get("cas"); c != "" {
+			index, _ := strconv.ParseUint(c, 10, 64)
+			if !f.indexMatches(key, index) {
+				w.Write([]byte("false"))
+				return
+			}
+		}
+		f.set(key, value, flags)
+		w.Write([]byte("true"))
+	case http.MethodDelete:
+		if c := q.Get("cas"); c != "" {
+			index, _ := strconv.ParseUint(c, 10, 64)
+			if !f.indexMatches(key, index) {
+				w.Write([]byte("false"))
+				return
+			}
+		}
+		f.delete(key, recurse)
+		w.Write([]byte("true"))
+	}
+}
+
+func (f *fakeConsul) txn(w http.ResponseWriter, r *http.Request) {
+	var ops []fakeTxnOp
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// like Consul, refuse transactions over the operation limit outright
+	if len(ops) > maxTxnOps {
+		http.Error(w, "Transaction contains too many operations", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if f.beforeTxn != nil {
+		f.beforeTxn(f)
+		f.beforeTxn = nil
+	}
+
+	// checks run first, so a failed transaction changes nothing
+	type txnError struct {
+		OpIndex int
+		What    string
+	}
+	var errs []txnError
+	for i, op := range ops {
+		kv := op.KV
+		switch kv.Verb {
+		case "cas", "delete-cas", "check-index":
+			if !f.indexMatches(kv.Key, kv.Index) {
+				errs = append(errs, txnError{i, "index mismatch for " + kv.Key})
+			}
+		case "check-not-exists":
+			if _, ok := f.pairs[kv.Key]; ok {
+				errs = append(errs, txnError{i, kv.Key + " exists"})
+			}
+		case "get", "set", "delete", "delete-tree", "get-tree":
+		default:
+			errs = append(errs, txnError{i, "unsupported verb " + kv.Verb})
+		}
+	}
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"Errors": errs})
+		return
+	}
+
+	type txnResult struct {
+		KV *fakePair
+	}
+	results := []txnResult{}
+	for _, op := range ops {
+		kv := op.KV
+		switch kv.Verb {
+		case "set", "cas":
+			f.set(kv.Key, kv.Value, kv.Flags)
+			results = append(results, txnResult{f.pairs[kv.Key]})
+		case "delete", "delete-cas":
+			f.delete(kv.Key, false)
+		case "delete-tree":
+			f.delete(kv.Key, true)
+		case "get", "check-index":
+			if p, ok := f.pairs[kv.Key]; ok {
+				results = append(results, txnResult{p})
+			}
+		case "get-tree":
+			for _, k := range f.listKeys(kv.Key, "") {
+				results = append(results, txnResult{f.pairs[k]})
+			}
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"Results": results})
+}
+
+// indexMatches implements check-and-set: index 0 means the key must not
+// exist, anything else has to equal its ModifyIndex.
+func (f *fakeConsul) indexMatches(key string, index uint64) bool {
+	p, ok := f.pairs[key]
+	if index == 0 {
+		return !ok
+	}
+	return ok && p.ModifyIndex == index
+}
+
+func (f *fakeConsul) set(key string, value []byte, flags uint64) {
+	f.index++
+	p, ok := f.pairs[key]
+	if !ok {
+		p = &fakePair{Key: key, CreateIndex: f.index}
+		f.pairs[key] = p
+	}
+	p.ModifyIndex = f.index
+	p.Flags = flags
+	p.Value = value
+}
+
+func (f *fakeConsul) delete(key string, recurse bool) {
+	f.index++
+	if !recurse {
+		delete(f.pairs, key)
+		return
+	}
+	for k := range f.pairs {
+		if strings.HasPrefix(k, key) {
+			delete(f.pairs, k)
+		}
+	}
+}
+
+func (f *fakeConsul) listKeys(prefix string, separator string) []string {
+	seen := map[string]bool{}
+	keys := []string{}
+	for k := range f.pairs {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if separator != "" {
+			if i := strings.Index(k[len(prefix):], separator); i >= 0 {
+				k = k[:len(prefix)+i+len(separator)]
+			}
+		}
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
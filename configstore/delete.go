@@ -0,0 +1,124 @@
+package poststore
+
+import (
+	model "ars-projekat/model"
+	tracer "ars-projekat/tracer"
+	"context"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"sort"
+)
+
+func (ps *ConfigStore) DeleteConfigVersions(ctx context.Context, id string) (*model.DeletedJSON, error) {
+	span := tracer.StartSpanFromContext(ctx, "DeleteConfigVersions")
+	defer span.Finish()
+
+	prefix := fmt.Sprintf("%s%s/", configsPrefix, id)
+
+	versions, _, err := ps.deleteVersions(ctx, prefix, func(key string) (string, bool) {
+		_, version, ok := parseConfigKey(key)
+		return version, ok
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, ErrConfigNotFound
+	}
+
+	return &model.DeletedJSON{Deleted: id, Versions: versions}, nil
+}
+
+func (ps *ConfigStore) DeleteGroupVersions(ctx context.Context, id string) (*model.DeletedJSON, error) {
+	span := tracer.StartSpanFromContext(ctx, "DeleteGroupVersions")
+	defer span.Finish()
+
+	prefix := fmt.Sprintf("%s%s/", groupsPrefix, id)
+
+	versions, configs, err := ps.deleteVersions(ctx, prefix, func(key string) (string, bool) {
+		_, version, _, _, ok := parseGroupKey(key)
+		return version, ok
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, ErrGroupNotFound
+	}
+
+	return &model.DeletedJSON{Deleted: id, Versions: versions, Configs: configs}, nil
+}
+
+// deleteVersions tombstones every version found under the id prefix, one
+// tombstone per version, and then removes the pairs it listed. An id can hold
+// more pairs than fit in one transaction, so the ops are committed in
+// batches, all tombstones ahead of the first delete: whatever is removed can
+// be restored. Every delete is a check-and-set against the index the pair was
+// listed at, so nothing written or changed since is removed, and a concurrent
+// change stops the delete with ErrConflict, leaving the pairs of later
+// batches in place. It returns the versions it removed, sorted, and the
+// number of pairs they held.
+func (ps *ConfigStore) deleteVersions(ctx context.Context, prefix string, version func(key string) (string, bool)) ([]string, int, error) {
+	kv := ps.kv(ctx)
+
+	listSpan := tracer.StartSpanFromContext(ctx, "List")
+	before, _, err := kv.List(prefix, nil)
+	listSpan.Finish()
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	byVersion := map[string]api.KVPairs{}
+	for _, pair := range before {
+		v, ok := version(pair.Key)
+		if !ok {
+			return nil, 0, fmt.Errorf("unexpected key %q", pair.Key)
+		}
+		byVersion[v] = append(byVersion[v], pair)
+	}
+
+	versions := make([]string, 0, len(byVersion))
+	for v := range byVersion {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+
+	if len(versions) == 0 {
+		return versions, 0, nil
+	}
+
+	ops := api.KVTxnOps{}
+	for _, v := range versions {
+		t, err := tombstonePair(ctx, prefix+v+"/", byVersion[v])
+		if err != nil {
+			return nil, 0, err
+		}
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVCAS, Key: t.Key, Value: t.Value, Flags: t.Flags, Index: 0})
+	}
+	for _, pair := range before {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVDeleteCAS, Key: pair.Key, Index: pair.ModifyIndex})
+	}
+
+	tombstones := len(versions)
+	committed, ok, _, err := ps.commitBatches(ctx, ops)
+
+	// the pairs removed before a failed batch are gone all the same
+	if deleted := committed - tombstones; deleted > 0 {
+		ps.recordAudit(ctx, model.AuditDelete, prefix, before[:deleted], nil)
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !ok {
+		return nil, 0, ErrConflict
+	}
+
+	return versions, len(before), nil
+}
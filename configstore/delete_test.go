@@ -0,0 +1,198 @@
+package poststore
+
+import (
+	"ars-projekat/model"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeleteConfigVersions(t *testing.T) {
+	tests := []struct {
+		name       string
+		concurrent func(f *fakeConsul, id string)
+		wantErr    error
+		wantLive   int
+	}{
+		{
+			name:     "every version",
+			wantLive: 0,
+		},
+		{
+			name: "version created after listing is kept",
+			concurrent: func(f *fakeConsul, id string) {
+				f.set(constructConfigKey(id, "3"), []byte(`{"key":"k","value":"v"}`), 0)
+			},
+			wantLive: 1,
+		},
+		{
+			name: "version changed after listing fails the delete",
+			concurrent: func(f *fakeConsul, id string) {
+				f.set(constructConfigKey(id, "2"), []byte(`{"key":"k","value":"changed"}`), 0)
+			},
+			wantErr:  ErrConflict,
+			wantLive: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, fake := newTestStore(t)
+			ctx := context.Background()
+
+			id, err := ps.CreateConfig(ctx, &model.ConfigJSON{Key: "k", Value: "v", Version: "1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ps.CreateConfigVersion(ctx, id, &model.ConfigJSON{Key: "k", Value: "v", Version: "2"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.concurrent != nil {
+				fake.beforeTxn = func(f *fakeConsul) { tt.concurrent(f, id) }
+			}
+
+			deleted, err := ps.DeleteConfigVersions(ctx, id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DeleteConfigVersions() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if live := fake.keys(configsPrefix + id + "/"); len(live) != tt.wantLive {
+				t.Errorf("live keys = %v, want %d", live, tt.wantLive)
+			}
+
+			tombstones := fake.keys(constructTombstoneKey(configsPrefix + id + "/"))
+			if err != nil {
+				if len(tombstones) != 0 {
+					t.Errorf("tombstones after a failed delete = %v, want none", tombstones)
+				}
+				return
+			}
+			if len(deleted.Versions) != 2 || len(tombstones) != 2 {
+				t.Errorf("deleted %v with tombstones %v, want versions 1 and 2", deleted.Versions, tombstones)
+			}
+		})
+	}
+}
+
+func TestDeleteGroupConcurrently(t *testing.T) {
+	ps, fake := newTestStore(t)
+	ctx := context.Background()
+
+	id, err := ps.CreateGroup(ctx, &model.GroupJSON{
+		Version: "1",
+		Configs: []model.GroupConfigJSON{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the other delete finishes between the list and the transaction
+	fake.beforeTxn = func(f *fakeConsul) {
+		f.delete(constructGroupKey(id, "1", ""), true)
+		f.set(generateTombstoneKey(constructGroupKey(id, "1", ""), time.Now()), []byte(`{}`), 0)
+	}
+
+	if _, err := ps.DeleteGroup(ctx, id, "1"); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("DeleteGroup() error = %v, want %v", err, ErrGroupNotFound)
+	}
+
+	if tombstones := fake.keys(constructTombstoneKey(constructGroupKey(id, "1", ""))); len(tombstones) != 1 {
+		t.Errorf("tombstones = %v, want only the one of the other delete", tombstones)
+	}
+}
+
+// largeGroup returns a group version with more configs than fit in one
+// transaction.
+func largeGroup(version string) *model.GroupJSON {
+	group := &model.GroupJSON{Version: version}
+	for i := 0; i < maxTxnOps+6; i++ {
+		group.Configs = append(group.Configs, model.GroupConfigJSON{Key: fmt.Sprintf("key%d", i), Value: "v"})
+	}
+	return group
+}
+
+func TestDeleteVersionsBeyondTxnLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		create  func(ps *ConfigStore, ctx context.Context) (string, error)
+		delete  func(ps *ConfigStore, ctx context.Context, id string) (*model.DeletedJSON, error)
+		prefix  string
+		wantVer int
+	}{
+		{
+			name: "config with many versions",
+			create: func(ps *ConfigStore, ctx context.Context) (string, error) {
+				id, err := ps.CreateConfig(ctx, &model.ConfigJSON{Key: "k", Value: "v", Version: "0"})
+				for i := 1; err == nil && i < maxTxnOps; i++ {
+					_, err = ps.CreateConfigVersion(ctx, id, &model.ConfigJSON{Key: "k", Value: "v", Version: strconv.Itoa(i)})
+				}
+				return id, err
+			},
+			delete:  (*ConfigStore).DeleteConfigVersions,
+			prefix:  configsPrefix,
+			wantVer: maxTxnOps,
+		},
+		{
+			name: "group with many configs",
+			create: func(ps *ConfigStore, ctx context.Context) (string, error) {
+				return ps.CreateGroup(ctx, largeGroup("1"))
+			},
+			delete:  (*ConfigStore).DeleteGroupVersions,
+			prefix:  groupsPrefix,
+			wantVer: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, fake := newTestStore(t)
+			ctx := context.Background()
+
+			id, err := tt.create(ps, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			deleted, err := tt.delete(ps, ctx, id)
+			if err != nil {
+				t.Fatalf("delete error = %v", err)
+			}
+
+			if live := fake.keys(tt.prefix + id + "/"); len(live) != 0 {
+				t.Errorf("%d live keys left, want none", len(live))
+			}
+			if tombstones := fake.keys(constructTombstoneKey(tt.prefix + id + "/")); len(deleted.Versions) != tt.wantVer || len(tombstones) != tt.wantVer {
+				t.Errorf("deleted %d versions with %d tombstones, want %d", len(deleted.Versions), len(tombstones), tt.wantVer)
+			}
+		})
+	}
+}
+
+func TestDeleteLargeGroup(t *testing.T) {
+	ps, fake := newTestStore(t)
+	ctx := context.Background()
+
+	id, err := ps.CreateGroup(ctx, largeGroup("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := ps.DeleteGroup(ctx, id, "1")
+	if err != nil {
+		t.Fatalf("DeleteGroup() error = %v", err)
+	}
+	if deleted.Configs != maxTxnOps+6 {
+		t.Errorf("DeleteGroup() removed %d configs, want %d", deleted.Configs, maxTxnOps+6)
+	}
+
+	if live := fake.keys(constructGroupKey(id, "1", "")); len(live) != 0 {
+		t.Errorf("%d live keys left, want none", len(live))
+	}
+	if tombstones := fake.keys(constructTombstoneKey(constructGroupKey(id, "1", ""))); len(tombstones) != 1 {
+		t.Errorf("tombstones = %v, want one", tombstones)
+	}
+}
@@ -0,0 +1,175 @@
+package poststore
+
+import (
+	model "ars-projekat/model"
+	tracer "ars-projekat/tracer"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"strings"
+)
+
+// Export passes every stored config, of every namespace, to emit as it is
+// read, one namespace and key family at a time, and stops at the first error.
+// The namespaces themselves are not part of it and have to exist before it is
+// imported.
+func (ps *ConfigStore) Export(ctx context.Context, emit func(*model.RecordJSON) error) error {
+	span := tracer.StartSpanFromContext(ctx, "Export")
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	names, err := ps.namespaceNames(ctx)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	for _, name := range append([]string{""}, names...) {
+		for _, prefix := range []string{configsPrefix, groupsPrefix} {
+			if err := ps.export(WithNamespace(ctx, name), prefix, emit); err != nil {
+				if name != "" {
+					err = fmt.Errorf("namespace %s: %w", name, err)
+				}
+				tracer.LogError(span, err)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (ps *ConfigStore) export(ctx context.Context, prefix string, emit func(*model.RecordJSON) error) error {
+	listSpan := tracer.StartSpanFromContext(ctx, "List")
+	pairs, _, err := ps.kv(ctx).List(prefix, nil)
+	listSpan.Finish()
+
+	if err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		record, err := pairToRecord(pair)
+		if err != nil {
+			return err
+		}
+		record.Namespace = NamespaceFromContext(ctx)
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Import writes a single exported record back under its original key, in
+// its namespace, which has to exist. Records whose stored value is already
+// identical are skipped, so replaying the same stream twice leaves the store
+// untouched.
+func (ps *ConfigStore) Import(ctx context.Context, record *model.RecordJSON) (bool, error) {
+	span := tracer.StartSpanFromContext(ctx, "Import")
+	defer span.Finish()
+
+	if record.Namespace != "" {
+		if _, _, err := ps.getNamespace(ctx, record.Namespace); err != nil {
+			tracer.LogError(span, err)
+			return false, fmt.Errorf("namespace %s: %w", record.Namespace, err)
+		}
+	}
+	ctx = WithNamespace(ctx, record.Namespace)
+
+	kv := ps.kv(ctx)
+
+	var key, idPrefix string
+	switch record.Kind {
+	case model.RecordConfig:
+		key = constructConfigKey(record.Id, record.Version)
+		idPrefix = configsPrefix + record.Id + "/"
+	case model.RecordGroup:
+		idPrefix = groupsPrefix + record.Id + "/"
+		labels := model.DecodeJSONLabels(ctx, record.Labels)
+		if record.ConfigId == "" {
+			key = constructGroupKey(record.Id, record.Version, labels)
+		} else {
+			key = constructGroupConfigKey(record.Id, record.ConfigId, record.Version, labels)
+		}
+	default:
+		return false, fmt.Errorf("unknown record kind %q", record.Kind)
+	}
+
+	data, err := json.Marshal(record.Config)
+	if err != nil {
+		return false, err
+	}
+
+	getSpan := tracer.StartSpanFromContext(ctx, "Get")
+	existing, _, err := kv.Get(key, nil)
+	getSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return false, err
+	}
+
+	if existing != nil && bytes.Equal(existing.Value, data) {
+		return false, nil
+	}
+
+	p := &api.KVPair{Key: key, Value: data}
+
+	// the first record of an id creates it, counted against the quota
+	keysSpan := tracer.StartSpanFromContext(ctx, "Keys")
+	ids, _, err := kv.Keys(idPrefix, "", nil)
+	keysSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return false, err
+	}
+
+	if len(ids) == 0 {
+		err = ps.createWithinQuota(ctx, record.Kind, p)
+	} else {
+		putSpan := tracer.StartSpanFromContext(ctx, "Put")
+		_, err = kv.Put(p, nil)
+		putSpan.Finish()
+	}
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return false, err
+	}
+
+	ps.recordAudit(ctx, model.AuditImport, key, pairsOf(existing), api.KVPairs{p})
+
+	return true, nil
+}
+
+func pairToRecord(pair *api.KVPair) (*model.RecordJSON, error) {
+	record := &model.RecordJSON{}
+
+	var ok bool
+	switch {
+	case strings.HasPrefix(pair.Key, configsPrefix):
+		record.Kind = model.RecordConfig
+		record.Id, record.Version, ok = parseConfigKey(pair.Key)
+	case strings.HasPrefix(pair.Key, groupsPrefix):
+		var labels string
+		record.Kind = model.RecordGroup
+		record.Id, record.Version, labels, record.ConfigId, ok = parseGroupKey(pair.Key)
+		record.Labels = model.ParseLabels(labels)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unexpected key %q", pair.Key)
+	}
+
+	if err := json.Unmarshal(pair.Value, &record.Config); err != nil {
+		return nil, fmt.Errorf("key %q: %w", pair.Key, err)
+	}
+
+	return record, nil
+}
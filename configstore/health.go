@@ -0,0 +1,31 @@
+package poststore
+
+import (
+	tracer "ars-projekat/tracer"
+	"context"
+	"errors"
+	"github.com/hashicorp/consul/api"
+)
+
+var ErrNoLeader = errors.New("Consul has no leader")
+
+// Leader returns the address of the Consul raft leader. Without a leader the
+// cluster cannot serve consistent reads or any writes, so that is an error.
+func (ps *ConfigStore) Leader(ctx context.Context) (string, error) {
+	span := tracer.StartSpanFromContext(ctx, "Leader")
+	defer span.Finish()
+
+	var leader string
+	_, err := ps.cli.Raw().Query("/v1/status/leader", &leader, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		tracer.LogError(span, err)
+		return "", err
+	}
+
+	if leader == "" {
+		tracer.LogError(span, ErrNoLeader)
+		return "", ErrNoLeader
+	}
+
+	return leader, nil
+}
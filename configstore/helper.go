@@ -1,8 +1,13 @@
 package poststore
 
 import (
+	model "ars-projekat/model"
+	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
+	"github.com/hashicorp/consul/api"
+	"strings"
+	"time"
 )
 
 const (
@@ -12,6 +17,20 @@ const (
 	groupConfig         = "groups/%s/%s/%s/%s/"
 	groupConfigNoLabels = "groups/%s/%s/%s/"
 	idempotency         = "idempotency/%s/"
+	policies            = "policies/%s/"
+	audit               = "audit/%020d-%s/"
+	tombstones          = "tombstones/%s"
+	tombstone           = "tombstones/%s%020d"
+	namespaces          = "namespaces/%s/"
+	namespaceData       = "ns/%s/"
+	quotaCounter        = "quota/%s"
+
+	configsPrefix    = "configs/"
+	groupsPrefix     = "groups/"
+	policyPrefix     = "policies/"
+	auditPrefix      = "audit/"
+	tombstonesPrefix = "tombstones/"
+	namespacesPrefix = "namespaces/"
 )
 
 func createId() string {
@@ -48,6 +67,47 @@ func constructGroupConfigKey(groupId string, configId string, version string, la
 	}
 }
 
+func constructPolicyKey(id string) string {
+	return fmt.Sprintf(policies, id)
+}
+
+func constructNamespaceKey(name string) string {
+	return fmt.Sprintf(namespaces, name)
+}
+
+func constructQuotaKey(kind string) string {
+	return fmt.Sprintf(quotaCounter, kind)
+}
+
+// namespacePrefix is where the data of a namespace is kept, and empty for
+// the data outside of every namespace.
+func namespacePrefix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf(namespaceData, name)
+}
+
+// generateAuditKey orders audit entries by time, so listing the prefix returns
+// them oldest first.
+func generateAuditKey(t time.Time) (string, string) {
+	id := uuid.New().String()
+	return fmt.Sprintf(audit, t.UnixNano(), id), id
+}
+
+// constructTombstoneKey is the prefix of the tombstones of a deleted config or
+// group version, under the key the version itself was stored at.
+func constructTombstoneKey(resource string) string {
+	return fmt.Sprintf(tombstones, resource)
+}
+
+// generateTombstoneKey gives every deletion of a version a tombstone of its
+// own, ordered by time, so deleting a version that was created again does not
+// replace the tombstone of the earlier one.
+func generateTombstoneKey(resource string, t time.Time) string {
+	return fmt.Sprintf(tombstone, resource, t.UnixNano())
+}
+
 func generateIdempotencyKey() (string, string) {
 	id := uuid.New().String()
 	return constructIdempotencyKey(id), id
@@ -56,3 +116,56 @@ func generateIdempotencyKey() (string, string) {
 func constructIdempotencyKey(key string) string {
 	return fmt.Sprintf(idempotency, key)
 }
+
+func parseConfigKey(key string) (string, string, bool) {
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(key, configsPrefix), "/"), "/")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// parseGroupKey splits a key under groups/ into group id, version, labels and
+// config id. Keys written by AddConfigToGroup carry no config id, so a trailing
+// segment is only treated as labels when it looks like one (k=v).
+func parseGroupKey(key string) (string, string, string, string, bool) {
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(key, groupsPrefix), "/"), "/")
+	switch len(parts) {
+	case 2:
+		return parts[0], parts[1], "", "", true
+	case 3:
+		if strings.Contains(parts[2], "=") {
+			return parts[0], parts[1], parts[2], "", true
+		}
+		return parts[0], parts[1], "", parts[2], true
+	case 4:
+		return parts[0], parts[1], parts[2], parts[3], true
+	}
+
+	return "", "", "", "", false
+}
+
+// pairMeta describes a stored config. ModifyIndex carries no notion of time,
+// so the modification time comes from the one encodeConfig records in the
+// value; Flags is left to clients of Consul.
+func pairMeta(pair *api.KVPair) *model.Meta {
+	meta := &model.Meta{Index: pair.ModifyIndex}
+
+	var stamp struct {
+		Modified time.Time `json:"modified"`
+	}
+	if err := json.Unmarshal(pair.Value, &stamp); err == nil {
+		meta.Modified = stamp.Modified
+	}
+
+	return meta
+}
+
+func pairsOf(pair *api.KVPair) api.KVPairs {
+	if pair == nil {
+		return nil
+	}
+
+	return api.KVPairs{pair}
+}
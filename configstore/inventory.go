@@ -0,0 +1,201 @@
+package poststore
+
+import (
+	tracer "ars-projekat/tracer"
+	"context"
+	"github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	configsTotal = prometheus.NewDesc(
+		"configs_total",
+		"Number of configs in the store, by namespace.",
+		[]string{"namespace"}, nil,
+	)
+	configVersionsTotal = prometheus.NewDesc(
+		"config_versions_total",
+		"Number of config versions in the store, by namespace.",
+		[]string{"namespace"}, nil,
+	)
+	groupsTotal = prometheus.NewDesc(
+		"groups_total",
+		"Number of groups in the store, by namespace.",
+		[]string{"namespace"}, nil,
+	)
+	groupVersionsTotal = prometheus.NewDesc(
+		"group_versions_total",
+		"Number of group versions in the store, by namespace.",
+		[]string{"namespace"}, nil,
+	)
+	groupConfigsTotal = prometheus.NewDesc(
+		"group_configs_total",
+		"Number of configs held by a group, across its versions.",
+		[]string{"namespace", "group"}, nil,
+	)
+	labelSetsTotal = prometheus.NewDesc(
+		"label_sets_total",
+		"Number of distinct label sets used by group configs, by namespace.",
+		[]string{"namespace"}, nil,
+	)
+)
+
+// inventoryMetrics serves the gauges of the latest completed scan.
+var inventoryMetrics = &inventoryCollector{}
+
+// inventoryCollector publishes a whole scan at once: a scrape sees either the
+// previous scan or the new one, never a mix of both or the empty vectors in
+// between. Namespaces and groups gone since the last scan drop out with it.
+type inventoryCollector struct {
+	scanned atomic.Pointer[map[string]*inventory]
+}
+
+func (c *inventoryCollector) publish(scanned map[string]*inventory) {
+	c.scanned.Store(&scanned)
+}
+
+func (c *inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{configsTotal, configVersionsTotal, groupsTotal, groupVersionsTotal, groupConfigsTotal, labelSetsTotal} {
+		ch <- desc
+	}
+}
+
+func (c *inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	scanned := c.scanned.Load()
+	if scanned == nil {
+		return
+	}
+
+	gauge := func(desc *prometheus.Desc, value int, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value), labels...)
+	}
+	for namespace, inv := range *scanned {
+		gauge(configsTotal, inv.configs, namespace)
+		gauge(configVersionsTotal, inv.configVersions, namespace)
+		gauge(groupsTotal, len(inv.groupConfigs), namespace)
+		gauge(groupVersionsTotal, inv.groupVersions, namespace)
+		gauge(labelSetsTotal, inv.labelSets, namespace)
+		for id, n := range inv.groupConfigs {
+			gauge(groupConfigsTotal, n, namespace, id)
+		}
+	}
+}
+
+// inventory is what a scan found in one namespace.
+type inventory struct {
+	configs        int
+	configVersions int
+	groupVersions  int
+	groupConfigs   map[string]int
+	labelSets      int
+}
+
+// ScanInventory counts what the store holds in every namespace and publishes
+// it as gauges, labelled with the namespace, which is empty outside of them.
+// It only reads key names, which Consul serves without the values, and
+// accepts stale reads so any server can answer.
+func (ps *ConfigStore) ScanInventory(ctx context.Context) error {
+	span := tracer.StartSpanFromContext(ctx, "ScanInventory")
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	scanned := map[string]*inventory{}
+	err := ps.eachNamespace(ctx, func(ctx context.Context) error {
+		inv, err := ps.scanNamespace(ctx)
+		if err != nil {
+			return err
+		}
+		scanned[NamespaceFromContext(ctx)] = inv
+		return nil
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	inventoryMetrics.publish(scanned)
+
+	return nil
+}
+
+func (ps *ConfigStore) scanNamespace(ctx context.Context) (*inventory, error) {
+	kv := ps.kv(ctx)
+	q := &api.QueryOptions{AllowStale: true}
+
+	keysSpan := tracer.StartSpanFromContext(ctx, "Keys")
+	configKeys, _, err := kv.Keys(configsPrefix, "", q)
+	keysSpan.Finish()
+
+	if err != nil {
+		return nil, err
+	}
+
+	keysSpan = tracer.StartSpanFromContext(ctx, "Keys")
+	groupKeys, _, err := kv.Keys(groupsPrefix, "", q)
+	keysSpan.Finish()
+
+	if err != nil {
+		return nil, err
+	}
+
+	configs := map[string]bool{}
+	configVersions := 0
+	for _, key := range configKeys {
+		id, _, ok := parseConfigKey(key)
+		if !ok {
+			continue
+		}
+		configs[id] = true
+		configVersions++
+	}
+
+	groupVersions := map[string]bool{}
+	groupConfigs := map[string]int{}
+	labelSets := map[string]bool{}
+	for _, key := range groupKeys {
+		id, version, labels, _, ok := parseGroupKey(key)
+		if !ok {
+			continue
+		}
+		groupVersions[id+"/"+version] = true
+		groupConfigs[id]++
+		if labels != "" {
+			labelSets[labels] = true
+		}
+	}
+
+	return &inventory{
+		configs:        len(configs),
+		configVersions: configVersions,
+		groupVersions:  len(groupVersions),
+		groupConfigs:   groupConfigs,
+		labelSets:      len(labelSets),
+	}, nil
+}
+
+// RunInventory scans the store right away and then every inventory interval
+// until ctx is done.
+func (ps *ConfigStore) RunInventory(ctx context.Context) {
+	ticker := time.NewTicker(ps.inventoryInterval)
+	defer ticker.Stop()
+
+	for {
+		span := tracer.StartSpanFromContext(ctx, "RunInventory")
+		scanCtx := tracer.ContextWithSpan(ctx, span)
+
+		if err := ps.ScanInventory(scanCtx); err != nil && ctx.Err() == nil {
+			ps.logger.WarnContext(scanCtx, "inventory scan failed", slog.Any("error", err))
+		}
+		span.Finish()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
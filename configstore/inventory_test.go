@@ -0,0 +1,64 @@
+package poststore
+
+import (
+	"ars-projekat/model"
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+func TestScanInventory(t *testing.T) {
+	ps, _ := newTestStore(t)
+	ctx := context.Background()
+
+	if err := ps.CreateNamespace(ctx, &model.NamespaceJSON{Name: "team-a"}); err != nil {
+		t.Fatal(err)
+	}
+	id, err := ps.CreateConfig(ctx, &model.ConfigJSON{Key: "port", Value: "8080", Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.CreateConfigVersion(ctx, id, &model.ConfigJSON{Key: "port", Value: "8081", Version: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.CreateConfig(WithNamespace(ctx, "team-a"), &model.ConfigJSON{Key: "port", Value: "9090", Version: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ps.ScanInventory(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+# HELP config_versions_total Number of config versions in the store, by namespace.
+# TYPE config_versions_total gauge
+config_versions_total{namespace=""} 2
+config_versions_total{namespace="team-a"} 1
+# HELP configs_total Number of configs in the store, by namespace.
+# TYPE configs_total gauge
+configs_total{namespace=""} 1
+configs_total{namespace="team-a"} 1
+`
+	if err := testutil.CollectAndCompare(inventoryMetrics, strings.NewReader(want), "configs_total", "config_versions_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next scan replaces the whole set
+	if _, err := ps.DeleteConfigVersions(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.ScanInventory(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want = `
+# HELP configs_total Number of configs in the store, by namespace.
+# TYPE configs_total gauge
+configs_total{namespace=""} 0
+configs_total{namespace="team-a"} 1
+`
+	if err := testutil.CollectAndCompare(inventoryMetrics, strings.NewReader(want), "configs_total"); err != nil {
+		t.Fatal(err)
+	}
+}
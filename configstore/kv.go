@@ -0,0 +1,225 @@
+package poststore
+
+import (
+	tracer "ars-projekat/tracer"
+	"context"
+	"github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+	"time"
+)
+
+var (
+	kvDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "consul_kv_request_duration_seconds",
+			Help:    "Duration of Consul KV requests, by operation and key family.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"operation", "family"},
+	)
+	kvErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "consul_kv_errors_total",
+			Help: "Total number of failed Consul KV requests, by operation and key family.",
+		},
+		[]string{"operation", "family"},
+	)
+)
+
+// instrumentedKV wraps the Consul KV client with the same methods the store
+// uses, timing every request and counting its failures. Requests are bound
+// to ctx, so they are abandoned once the caller goes away.
+//
+// Keys are relative to root: it is prepended to every key sent to Consul and
+// trimmed from every key that comes back, so the rest of the store never
+// sees it.
+type instrumentedKV struct {
+	kv   *api.KV
+	ctx  context.Context
+	root string
+}
+
+// kv returns the KV client for the namespace carried by ctx.
+func (ps *ConfigStore) kv(ctx context.Context) instrumentedKV {
+	return instrumentedKV{kv: ps.cli.KV(), ctx: ctx, root: ps.root + namespacePrefix(NamespaceFromContext(ctx))}
+}
+
+// rootKV returns the KV client for what is shared by all namespaces, such as
+// policies, the audit log and the namespaces themselves.
+func (ps *ConfigStore) rootKV(ctx context.Context) instrumentedKV {
+	return instrumentedKV{kv: ps.cli.KV(), ctx: ctx, root: ps.root}
+}
+
+// rootPrefix turns the configured prefix into the form keys are joined with,
+// "" or a path ending in a slash.
+func rootPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+func (k instrumentedKV) pair(p *api.KVPair) *api.KVPair {
+	if p == nil || k.root == "" {
+		return p
+	}
+	prefixed := *p
+	prefixed.Key = k.root + p.Key
+	return &prefixed
+}
+
+func (k instrumentedKV) trim(p *api.KVPair) {
+	if p != nil {
+		p.Key = strings.TrimPrefix(p.Key, k.root)
+	}
+}
+
+// keyFamily is the first segment of a key, such as configs or idempotency.
+func keyFamily(key string) string {
+	family, _, _ := strings.Cut(key, "/")
+	if family == "" {
+		return "root"
+	}
+	return family
+}
+
+func observe(operation string, key string, start time.Time, err error) {
+	family := keyFamily(key)
+	kvDuration.WithLabelValues(operation, family).Observe(time.Since(start).Seconds())
+	if err != nil {
+		kvErrors.WithLabelValues(operation, family).Inc()
+	}
+}
+
+func (k instrumentedKV) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	start := time.Now()
+	pair, meta, err := k.kv.Get(k.root+key, q.WithContext(k.ctx))
+	observe("get", key, start, err)
+	k.trim(pair)
+	return pair, meta, err
+}
+
+func (k instrumentedKV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	start := time.Now()
+	pairs, meta, err := k.kv.List(k.root+prefix, q.WithContext(k.ctx))
+	observe("list", prefix, start, err)
+	for _, p := range pairs {
+		k.trim(p)
+	}
+	return pairs, meta, err
+}
+
+func (k instrumentedKV) Keys(prefix, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	start := time.Now()
+	keys, meta, err := k.kv.Keys(k.root+prefix, separator, q.WithContext(k.ctx))
+	observe("keys", prefix, start, err)
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], k.root)
+	}
+	return keys, meta, err
+}
+
+func (k instrumentedKV) Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error) {
+	start := time.Now()
+	meta, err := k.kv.Put(k.pair(p), q.WithContext(k.ctx))
+	observe("put", p.Key, start, err)
+	return meta, err
+}
+
+func (k instrumentedKV) CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	start := time.Now()
+	ok, meta, err := k.kv.CAS(k.pair(p), q.WithContext(k.ctx))
+	observe("cas", p.Key, start, err)
+	return ok, meta, err
+}
+
+func (k instrumentedKV) Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error) {
+	start := time.Now()
+	meta, err := k.kv.Delete(k.root+key, w.WithContext(k.ctx))
+	observe("delete", key, start, err)
+	return meta, err
+}
+
+func (k instrumentedKV) DeleteCAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	start := time.Now()
+	ok, meta, err := k.kv.DeleteCAS(k.pair(p), q.WithContext(k.ctx))
+	observe("delete_cas", p.Key, start, err)
+	return ok, meta, err
+}
+
+func (k instrumentedKV) DeleteTree(prefix string, w *api.WriteOptions) (*api.WriteMeta, error) {
+	start := time.Now()
+	meta, err := k.kv.DeleteTree(k.root+prefix, w.WithContext(k.ctx))
+	observe("delete_tree", prefix, start, err)
+	return meta, err
+}
+
+// Txn is labelled with the family of its first operation.
+func (k instrumentedKV) Txn(txn api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+	key := ""
+	if len(txn) > 0 {
+		key = txn[0].Key
+	}
+
+	if k.root != "" {
+		prefixed := make(api.KVTxnOps, len(txn))
+		for i, op := range txn {
+			o := *op
+			o.Key = k.root + op.Key
+			prefixed[i] = &o
+		}
+		txn = prefixed
+	}
+
+	start := time.Now()
+	ok, resp, meta, err := k.kv.Txn(txn, q.WithContext(k.ctx))
+	observe("txn", key, start, err)
+	if resp != nil {
+		for _, p := range resp.Results {
+			k.trim(p)
+		}
+	}
+	return ok, resp, meta, err
+}
+
+// maxTxnOps is the most operations Consul takes in one transaction.
+const maxTxnOps = 64
+
+// commitBatches commits ops in order, in as many transactions of at most
+// maxTxnOps as it takes, and stops at the first one that fails. It returns
+// how many ops were committed before that; the OpIndex of the errors in resp
+// counts from the start of ops.
+func (ps *ConfigStore) commitBatches(ctx context.Context, ops api.KVTxnOps) (int, bool, *api.KVTxnResponse, error) {
+	committed := 0
+	for committed < len(ops) {
+		end := min(committed+maxTxnOps, len(ops))
+
+		ok, resp, err := ps.commit(ctx, ops[committed:end])
+		if err != nil {
+			return committed, false, nil, err
+		}
+		if !ok {
+			for _, e := range resp.Errors {
+				e.OpIndex += committed
+			}
+			return committed, false, resp, nil
+		}
+
+		committed = end
+	}
+
+	return committed, true, &api.KVTxnResponse{}, nil
+}
+
+// commit sends the transaction that makes a change. Once sent, it is not
+// given up when the caller goes away, so the result is known and the audit
+// entry that follows matches what was written.
+func (ps *ConfigStore) commit(ctx context.Context, ops api.KVTxnOps) (bool, *api.KVTxnResponse, error) {
+	span := tracer.StartSpanFromContext(ctx, "Txn")
+	defer span.Finish()
+
+	ok, resp, _, err := ps.kv(context.WithoutCancel(ctx)).Txn(ops, nil)
+	return ok, resp, err
+}
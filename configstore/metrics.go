@@ -0,0 +1,21 @@
+package poststore
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Collectors returns the metrics maintained by the store, for registration
+// with the service's registry.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		// kv.go
+		kvDuration,
+		kvErrors,
+		// inventory.go
+		inventoryMetrics,
+		// rotation.go
+		rotationInProgress,
+		rotationScanned,
+		rotationRewrapped,
+		rotationErrors,
+		rotationLastSuccess,
+	}
+}
@@ -0,0 +1,368 @@
+package poststore
+
+import (
+	model "ars-projekat/model"
+	tracer "ars-projekat/tracer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNamespaceNotFound = errors.New("Namespace not found")
+	ErrNamespaceExists   = errors.New("Namespace already exists")
+	ErrQuotaExceeded     = errors.New("Namespace quota exceeded")
+)
+
+type namespaceKey struct{}
+
+// WithNamespace makes the store calls made with ctx act on the data of the
+// named namespace.
+func WithNamespace(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, name)
+}
+
+// NamespaceFromContext returns the namespace ctx acts on, or "" outside of
+// every namespace.
+func NamespaceFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(namespaceKey{}).(string)
+	return name
+}
+
+func (ps *ConfigStore) CreateNamespace(ctx context.Context, namespace *model.NamespaceJSON) error {
+	span := tracer.StartSpanFromContext(ctx, "CreateNamespace")
+	defer span.Finish()
+
+	kv := ps.rootKV(ctx)
+
+	namespace.Created = time.Now().UTC()
+	namespace.Usage = nil
+
+	data, err := json.Marshal(namespace)
+	if err != nil {
+		return err
+	}
+
+	p := &api.KVPair{Key: constructNamespaceKey(namespace.Name), Value: data}
+
+	// index 0 only writes the key if it does not exist yet
+	casSpan := tracer.StartSpanFromContext(ctx, "CAS")
+	ok, _, err := kv.CAS(p, nil)
+	casSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if !ok {
+		return ErrNamespaceExists
+	}
+
+	ps.recordAudit(ctx, model.AuditCreate, p.Key, nil, api.KVPairs{p})
+
+	return nil
+}
+
+// UpdateNamespaceQuota replaces the quota of a namespace. Lowering it below
+// the current usage only stops further configs and groups from being created.
+func (ps *ConfigStore) UpdateNamespaceQuota(ctx context.Context, name string, quota model.QuotaJSON) (*model.NamespaceJSON, error) {
+	span := tracer.StartSpanFromContext(ctx, "UpdateNamespaceQuota")
+	defer span.Finish()
+
+	kv := ps.rootKV(ctx)
+
+	namespace, pair, err := ps.getNamespace(ctx, name)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	namespace.Quota = quota
+
+	data, err := json.Marshal(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &api.KVPair{Key: pair.Key, Value: data, ModifyIndex: pair.ModifyIndex}
+
+	casSpan := tracer.StartSpanFromContext(ctx, "CAS")
+	ok, _, err := kv.CAS(p, nil)
+	casSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: namespace %s changed concurrently", ErrConflict, name)
+	}
+
+	ps.recordAudit(ctx, model.AuditUpdate, p.Key, api.KVPairs{pair}, api.KVPairs{p})
+
+	return ps.withUsage(ctx, namespace)
+}
+
+func (ps *ConfigStore) GetNamespace(ctx context.Context, name string) (*model.NamespaceJSON, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetNamespace")
+	defer span.Finish()
+
+	namespace, _, err := ps.getNamespace(ctx, name)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return ps.withUsage(ctx, namespace)
+}
+
+func (ps *ConfigStore) ListNamespaces(ctx context.Context) ([]*model.NamespaceJSON, error) {
+	span := tracer.StartSpanFromContext(ctx, "ListNamespaces")
+	defer span.Finish()
+
+	kv := ps.rootKV(ctx)
+
+	listSpan := tracer.StartSpanFromContext(ctx, "List")
+	data, _, err := kv.List(namespacesPrefix, nil)
+	listSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	namespaces := []*model.NamespaceJSON{}
+	for _, pair := range data {
+		namespace := &model.NamespaceJSON{}
+		if err := json.Unmarshal(pair.Value, namespace); err != nil {
+			return nil, err
+		}
+
+		namespace, err = ps.withUsage(ctx, namespace)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		namespaces = append(namespaces, namespace)
+	}
+
+	return namespaces, nil
+}
+
+func (ps *ConfigStore) CheckIfNamespaceExists(ctx context.Context, name string) (bool, error) {
+	span := tracer.StartSpanFromContext(ctx, "CheckIfNamespaceExists")
+	defer span.Finish()
+
+	_, _, err := ps.getNamespace(ctx, name)
+	if errors.Is(err, ErrNamespaceNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (ps *ConfigStore) getNamespace(ctx context.Context, name string) (*model.NamespaceJSON, *api.KVPair, error) {
+	kv := ps.rootKV(ctx)
+
+	getSpan := tracer.StartSpanFromContext(ctx, "Get")
+	pair, _, err := kv.Get(constructNamespaceKey(name), nil)
+	getSpan.Finish()
+
+	if err != nil {
+		return nil, nil, err
+	}
+	if pair == nil {
+		return nil, nil, ErrNamespaceNotFound
+	}
+
+	namespace := &model.NamespaceJSON{}
+	if err := json.Unmarshal(pair.Value, namespace); err != nil {
+		return nil, nil, err
+	}
+
+	return namespace, pair, nil
+}
+
+func (ps *ConfigStore) withUsage(ctx context.Context, namespace *model.NamespaceJSON) (*model.NamespaceJSON, error) {
+	configs, groups, err := ps.usage(WithNamespace(ctx, namespace.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	namespace.Usage = &model.QuotaJSON{Configs: configs, Groups: groups}
+	return namespace, nil
+}
+
+// usage counts the config and group ids in the namespace of ctx. Listing keys
+// up to the first separator returns a single key per id.
+func (ps *ConfigStore) usage(ctx context.Context) (int, int, error) {
+	kv := ps.kv(ctx)
+
+	keysSpan := tracer.StartSpanFromContext(ctx, "Keys")
+	configIds, _, err := kv.Keys(configsPrefix, "/", nil)
+	keysSpan.Finish()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	keysSpan = tracer.StartSpanFromContext(ctx, "Keys")
+	groupIds, _, err := kv.Keys(groupsPrefix, "/", nil)
+	keysSpan.Finish()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return len(configIds), len(groupIds), nil
+}
+
+// quotaAttempts bounds how often a create is retried when another create in
+// the same namespace got in between.
+const quotaAttempts = 3
+
+// createWithinQuota writes claim, the first pair of a new config or group,
+// after checking the quota of the namespace of ctx for kind. The check and
+// the write are made atomic through a counter of the creates of kind in the
+// namespace, which is incremented with a check-and-set in the same
+// transaction as claim: of two concurrent creates, only the one that counted
+// first gets written, and the other one counts again.
+func (ps *ConfigStore) createWithinQuota(ctx context.Context, kind string, claim *api.KVPair) error {
+	for attempt := 0; attempt < quotaAttempts; attempt++ {
+		guard, err := ps.checkQuota(ctx, kind)
+		if err != nil {
+			return err
+		}
+
+		ops := api.KVTxnOps{&api.KVTxnOp{Verb: api.KVSet, Key: claim.Key, Value: claim.Value}}
+		if guard != nil {
+			ops = append(ops, guard)
+		}
+
+		ok, _, err := ps.commit(ctx, ops)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return ErrConflict
+}
+
+// checkQuota fails with ErrQuotaExceeded when the namespace of ctx already
+// holds as many configs or groups, depending on kind, as its quota allows.
+// Otherwise it returns the counter update createWithinQuota writes along with
+// the create, or nil outside of every namespace and without a limit. The
+// usage itself is counted from the stored ids, so deletes need not touch the
+// counter.
+func (ps *ConfigStore) checkQuota(ctx context.Context, kind string) (*api.KVTxnOp, error) {
+	name := NamespaceFromContext(ctx)
+	if name == "" {
+		return nil, nil
+	}
+
+	span := tracer.StartSpanFromContext(ctx, "checkQuota")
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	namespace, _, err := ps.getNamespace(ctx, name)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	limit := namespace.Quota.Configs
+	if kind == model.RecordGroup {
+		limit = namespace.Quota.Groups
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	// the counter is read first, so a create committed after this point
+	// fails the check-and-set
+	kv := ps.kv(ctx)
+	counterKey := constructQuotaKey(kind)
+
+	getSpan := tracer.StartSpanFromContext(ctx, "Get")
+	counter, _, err := kv.Get(counterKey, nil)
+	getSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	configs, groups, err := ps.usage(ctx)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	used := configs
+	if kind == model.RecordGroup {
+		used = groups
+	}
+	if used >= limit {
+		return nil, fmt.Errorf("%w: namespace %s allows %d %ss", ErrQuotaExceeded, name, limit, kind)
+	}
+
+	creates, index := uint64(0), uint64(0)
+	if counter != nil {
+		creates, _ = strconv.ParseUint(string(counter.Value), 10, 64)
+		index = counter.ModifyIndex
+	}
+
+	return &api.KVTxnOp{Verb: api.KVCAS, Key: counterKey, Value: []byte(strconv.FormatUint(creates+1, 10)), Index: index}, nil
+}
+
+// namespaceNames lists the names of all namespaces.
+func (ps *ConfigStore) namespaceNames(ctx context.Context) ([]string, error) {
+	kv := ps.rootKV(ctx)
+
+	keysSpan := tracer.StartSpanFromContext(ctx, "Keys")
+	keys, _, err := kv.Keys(namespacesPrefix, "/", nil)
+	keysSpan.Finish()
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(key, namespacesPrefix), "/"))
+	}
+
+	return names, nil
+}
+
+// eachNamespace runs fn for the data outside of every namespace and then for
+// each namespace, with ctx bound to it. It goes on after a failure and
+// returns all of them.
+func (ps *ConfigStore) eachNamespace(ctx context.Context, fn func(ctx context.Context) error) error {
+	names, err := ps.namespaceNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{fn(WithNamespace(ctx, ""))}
+	for _, name := range names {
+		if err := fn(WithNamespace(ctx, name)); err != nil {
+			errs = append(errs, fmt.Errorf("namespace %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
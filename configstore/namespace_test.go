@@ -0,0 +1,211 @@
+package poststore
+
+import (
+	"ars-projekat/model"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNamespaceIsolation(t *testing.T) {
+	ps, fake := newTestStore(t)
+	ctx := context.Background()
+
+	if err := ps.CreateNamespace(ctx, &model.NamespaceJSON{Name: "team-a"}); err != nil {
+		t.Fatal(err)
+	}
+	teamA := WithNamespace(ctx, "team-a")
+
+	id, err := ps.CreateConfig(teamA, &model.ConfigJSON{Key: "k", Value: "v", Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := ps.GetConfig(teamA, id, "1"); err != nil {
+		t.Errorf("GetConfig() in its namespace: %v", err)
+	}
+	if _, _, err := ps.GetConfig(ctx, id, "1"); !errors.Is(err, ErrConfigNotFound) {
+		t.Errorf("GetConfig() outside namespaces = %v, want %v", err, ErrConfigNotFound)
+	}
+	if _, _, err := ps.GetConfig(WithNamespace(ctx, "team-b"), id, "1"); !errors.Is(err, ErrConfigNotFound) {
+		t.Errorf("GetConfig() in another namespace = %v, want %v", err, ErrConfigNotFound)
+	}
+
+	if keys := fake.keys("configs/"); len(keys) != 0 {
+		t.Errorf("keys outside namespaces = %v, want none", keys)
+	}
+	if keys := fake.keys("ns/team-a/configs/" + id + "/"); len(keys) != 1 {
+		t.Errorf("keys in namespace = %v, want one", keys)
+	}
+}
+
+func TestExportImportNamespaces(t *testing.T) {
+	ps, _ := newTestStore(t)
+	ctx := context.Background()
+
+	if err := ps.CreateNamespace(ctx, &model.NamespaceJSON{Name: "team-a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.CreateConfig(ctx, &model.ConfigJSON{Key: "k", Value: "root", Version: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.CreateConfig(WithNamespace(ctx, "team-a"), &model.ConfigJSON{Key: "k", Value: "team-a", Version: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []*model.RecordJSON
+	err := ps.Export(ctx, func(record *model.RecordJSON) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespaces := map[string]string{}
+	for _, record := range records {
+		namespaces[record.Config.Value] = record.Namespace
+	}
+	if len(records) != 2 || namespaces["root"] != "" || namespaces["team-a"] != "team-a" {
+		t.Fatalf("Export() namespaces = %v, want root outside and team-a in team-a", namespaces)
+	}
+
+	target, _ := newTestStore(t)
+	for _, record := range records {
+		if record.Namespace == "team-a" {
+			if _, err := target.Import(ctx, record); !errors.Is(err, ErrNamespaceNotFound) {
+				t.Errorf("Import() into a missing namespace = %v, want %v", err, ErrNamespaceNotFound)
+			}
+		}
+	}
+
+	if err := target.CreateNamespace(ctx, &model.NamespaceJSON{Name: "team-a"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if _, err := target.Import(ctx, record); err != nil {
+			t.Fatalf("Import(): %v", err)
+		}
+	}
+
+	for _, record := range records {
+		config, _, err := target.GetConfig(WithNamespace(ctx, record.Namespace), record.Id, record.Version)
+		if err != nil {
+			t.Fatalf("GetConfig() after import: %v", err)
+		}
+		if config.Value != record.Config.Value {
+			t.Errorf("imported value = %q, want %q", config.Value, record.Config.Value)
+		}
+	}
+}
+
+func TestExportStopsAtEmitError(t *testing.T) {
+	ps, _ := newTestStore(t)
+	ctx := context.Background()
+
+	if err := ps.CreateNamespace(ctx, &model.NamespaceJSON{Name: "team-a"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []context.Context{ctx, ctx, WithNamespace(ctx, "team-a")} {
+		if _, err := ps.CreateConfig(c, &model.ConfigJSON{Key: "k", Value: "v", Version: "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gone := errors.New("client gone")
+	emitted := 0
+	err := ps.Export(ctx, func(record *model.RecordJSON) error {
+		emitted++
+		return gone
+	})
+	if !errors.Is(err, gone) || emitted != 1 {
+		t.Errorf("Export() = %v after %d records, want %v after 1", err, emitted, gone)
+	}
+}
+
+func TestQuotaConcurrentCreates(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   int
+		wantErr error
+		want    int
+	}{
+		{name: "concurrent create takes the last slot", quota: 1, wantErr: ErrQuotaExceeded, want: 1},
+		{name: "room for both", quota: 2, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, fake := newTestStore(t)
+			ctx := context.Background()
+
+			if err := ps.CreateNamespace(ctx, &model.NamespaceJSON{Name: "team-a", Quota: model.QuotaJSON{Configs: tt.quota}}); err != nil {
+				t.Fatal(err)
+			}
+
+			// another replica creates a config between our count and our write
+			fake.beforeTxn = func(f *fakeConsul) {
+				f.set("ns/team-a/configs/other/1/", []byte(`{"key":"k","value":"v"}`), 0)
+				f.set("ns/team-a/"+constructQuotaKey(model.RecordConfig), []byte("1"), 0)
+			}
+
+			_, err := ps.CreateConfig(WithNamespace(ctx, "team-a"), &model.ConfigJSON{Key: "k", Value: "v", Version: "1"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateConfig() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if keys := fake.keys("ns/team-a/configs/"); len(keys) != tt.want {
+				t.Errorf("configs in namespace = %v, want %d", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaOnRestoreAndImport(t *testing.T) {
+	ps, _ := newTestStore(t)
+	ctx := context.Background()
+
+	if err := ps.CreateNamespace(ctx, &model.NamespaceJSON{Name: "team-a", Quota: model.QuotaJSON{Configs: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	teamA := WithNamespace(ctx, "team-a")
+
+	old, err := ps.CreateConfig(teamA, &model.ConfigJSON{Key: "k", Value: "old", Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.CreateConfigVersion(teamA, old, &model.ConfigJSON{Key: "k", Value: "old", Version: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.DeleteConfigVersions(teamA, old); err != nil {
+		t.Fatal(err)
+	}
+	current, err := ps.CreateConfig(teamA, &model.ConfigJSON{Key: "k", Value: "new", Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ps.RestoreConfig(teamA, old, "1"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("RestoreConfig() of another id at quota = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	record := &model.RecordJSON{Namespace: "team-a", Kind: model.RecordConfig, Id: "imported", Version: "1", Config: model.Config{Key: "k", Value: "v"}}
+	if _, err := ps.Import(ctx, record); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Import() of a new id at quota = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// versions of an id that is already counted stay within the quota
+	if _, err := ps.DeleteConfig(teamA, current, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.RestoreConfig(teamA, old, "1"); err != nil {
+		t.Fatalf("RestoreConfig() below quota = %v", err)
+	}
+	if _, err := ps.RestoreConfig(teamA, old, "2"); err != nil {
+		t.Errorf("RestoreConfig() of another version of a counted id = %v", err)
+	}
+	record.Id, record.Version = old, "3"
+	if _, err := ps.Import(ctx, record); err != nil {
+		t.Errorf("Import() of a version of a counted id = %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package poststore
+
+import (
+	model "ars-projekat/model"
+	tracer "ars-projekat/tracer"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/hashicorp/consul/api"
+)
+
+func (ps *ConfigStore) CreatePolicy(ctx context.Context, policy *model.PolicyJSON) (string, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreatePolicy")
+	defer span.Finish()
+
+	kv := ps.rootKV(ctx)
+
+	policy.Id = createId()
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return "", err
+	}
+
+	p := &api.KVPair{Key: constructPolicyKey(policy.Id), Value: data}
+
+	putSpan := tracer.StartSpanFromContext(ctx, "Put")
+	_, err = kv.Put(p, nil)
+	putSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return "", err
+	}
+
+	ps.recordAudit(ctx, model.AuditCreate, p.Key, nil, api.KVPairs{p})
+
+	return policy.Id, nil
+}
+
+func (ps *ConfigStore) ListPolicies(ctx context.Context) ([]*model.PolicyJSON, error) {
+	span := tracer.StartSpanFromContext(ctx, "ListPolicies")
+	defer span.Finish()
+
+	kv := ps.rootKV(ctx)
+
+	listSpan := tracer.StartSpanFromContext(ctx, "List")
+	data, _, err := kv.List(policyPrefix, nil)
+	listSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	policies := []*model.PolicyJSON{}
+	for _, pair := range data {
+		policy := &model.PolicyJSON{}
+		err = json.Unmarshal(pair.Value, policy)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+func (ps *ConfigStore) DeletePolicy(ctx context.Context, id string) (map[string]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "DeletePolicy")
+	defer span.Finish()
+
+	kv := ps.rootKV(ctx)
+
+	policyKey := constructPolicyKey(id)
+
+	getSpan := tracer.StartSpanFromContext(ctx, "Get")
+	pair, _, err := kv.Get(policyKey, nil)
+	getSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if pair == nil {
+		return nil, errors.New("Policy not found")
+	}
+
+	deleteSpan := tracer.StartSpanFromContext(ctx, "Delete")
+	_, err = kv.Delete(policyKey, nil)
+	deleteSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	ps.recordAudit(ctx, model.AuditDelete, policyKey, api.KVPairs{pair}, nil)
+
+	return map[string]string{"Deleted": id}, nil
+}
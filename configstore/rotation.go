@@ -0,0 +1,293 @@
+package poststore
+
+import (
+	"ars-projekat/auth"
+	model "ars-projekat/model"
+	tracer "ars-projekat/tracer"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRotationRunning = errors.New("a key rotation is already running")
+
+var (
+	rotationInProgress = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "secret_rotation_in_progress",
+			Help: "Whether a key-encryption key rotation is running.",
+		},
+	)
+	rotationScanned = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "secret_rotation_scanned_total",
+			Help: "Total number of secret configs examined by key rotations.",
+		},
+	)
+	rotationRewrapped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "secret_rotation_rewrapped_total",
+			Help: "Total number of data keys re-wrapped under a new key-encryption key.",
+		},
+	)
+	rotationErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "secret_rotation_errors_total",
+			Help: "Total number of secret configs a key rotation failed to re-wrap.",
+		},
+	)
+	rotationLastSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "secret_rotation_last_success_timestamp_seconds",
+			Help: "Unix time of the last key rotation that finished without errors.",
+		},
+	)
+)
+
+// rotation tracks the progress of the current or last key rotation.
+type rotation struct {
+	mu     sync.Mutex
+	status model.RotationJSON
+}
+
+func (r *rotation) update(f func(status *model.RotationJSON)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f(&r.status)
+}
+
+func (ps *ConfigStore) RotationStatus() model.RotationJSON {
+	ps.rotation.mu.Lock()
+	defer ps.rotation.mu.Unlock()
+
+	return ps.rotation.status
+}
+
+// StartKeyRotation reloads the key-encryption key file and re-wraps, in the
+// background, every stored data key under the key it now holds. Reads keep
+// working throughout, since the keyring still knows every earlier key.
+//
+// Only this replica reloads the file here. The others pick the new key up from
+// their own key file the first time they meet a data key wrapped by it, so the
+// file has to be replaced on every replica before a rotation is started, with
+// the former key added to the previous key files for later restarts.
+func (ps *ConfigStore) StartKeyRotation(ctx context.Context) (model.RotationJSON, error) {
+	span := tracer.StartSpanFromContext(ctx, "StartKeyRotation")
+	defer span.Finish()
+
+	if ps.keyring == nil {
+		return model.RotationJSON{}, ErrNoKeyring
+	}
+
+	ps.rotation.mu.Lock()
+	defer ps.rotation.mu.Unlock()
+
+	if ps.rotation.status.InProgress {
+		return ps.rotation.status, ErrRotationRunning
+	}
+
+	keyId, err := ps.keyring.Reload()
+	if err != nil {
+		tracer.LogError(span, err)
+		return model.RotationJSON{}, err
+	}
+
+	ps.rotation.status = model.RotationJSON{
+		KeyId:      keyId,
+		InProgress: true,
+		StartedAt:  time.Now().UTC(),
+	}
+	rotationInProgress.Set(1)
+
+	// The rotation outlives the request that started it, so it only keeps the
+	// caller's identity and trace for auditing.
+	rotationCtx := tracer.ContextWithSpan(context.Background(), tracer.StartSpanFromContext(ctx, "RotateKeys"))
+	if identity, ok := auth.IdentityFromContext(ctx); ok {
+		rotationCtx = auth.WithIdentity(rotationCtx, identity)
+	}
+	rotationCtx = model.WithRequestID(rotationCtx, model.RequestIDFromContext(ctx))
+
+	go ps.rotateKeys(rotationCtx)
+
+	return ps.rotation.status, nil
+}
+
+func (ps *ConfigStore) rotateKeys(ctx context.Context) {
+	span := tracer.SpanFromContext(ctx)
+	defer span.Finish()
+
+	failed := false
+	err := ps.eachNamespace(ctx, func(ctx context.Context) error {
+		var errs []error
+		for _, prefix := range []string{configsPrefix, groupsPrefix, tombstonesPrefix} {
+			errs = append(errs, ps.rotatePrefix(ctx, prefix))
+		}
+		return errors.Join(errs...)
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		failed = true
+	}
+
+	now := time.Now().UTC()
+	var status model.RotationJSON
+	ps.rotation.update(func(s *model.RotationJSON) {
+		s.InProgress = false
+		s.FinishedAt = &now
+		failed = failed || s.Errors > 0
+		status = *s
+	})
+	rotationInProgress.Set(0)
+
+	ps.logger.InfoContext(ctx, "key rotation finished",
+		slog.String("key_id", status.KeyId),
+		slog.Int("scanned", status.Scanned),
+		slog.Int("rewrapped", status.Rewrapped),
+		slog.Int("errors", status.Errors),
+	)
+
+	if !failed {
+		rotationLastSuccess.Set(float64(now.Unix()))
+	}
+}
+
+func (ps *ConfigStore) rotatePrefix(ctx context.Context, prefix string) error {
+	kv := ps.kv(ctx)
+
+	listSpan := tracer.StartSpanFromContext(ctx, "List")
+	data, _, err := kv.List(prefix, nil)
+	listSpan.Finish()
+
+	if err != nil {
+		ps.rotationFailed(err)
+		return err
+	}
+
+	for _, pair := range data {
+		rewrapped, err := ps.rewrapPair(ctx, pair)
+		if err != nil {
+			ps.logger.WarnContext(ctx, "re-wrapping data key failed", slog.String("key", pair.Key), slog.Any("error", err))
+			ps.rotationFailed(err)
+			continue
+		}
+
+		if rewrapped {
+			rotationRewrapped.Inc()
+			ps.rotation.update(func(status *model.RotationJSON) {
+				status.Rewrapped++
+			})
+		}
+	}
+
+	return nil
+}
+
+// rewrapPair re-wraps the data keys held by a single stored config, or by the
+// configs kept in a tombstone. The write is a check-and-set against the index
+// the pair was read at, so a concurrent change is reported as an error rather
+// than overwritten.
+func (ps *ConfigStore) rewrapPair(ctx context.Context, pair *api.KVPair) (bool, error) {
+	var data []byte
+	var changed bool
+	var err error
+	if strings.HasPrefix(pair.Key, tombstonesPrefix) {
+		data, changed, err = ps.rewrapTombstone(pair.Value)
+	} else {
+		data, changed, err = ps.rewrapConfig(pair.Value)
+	}
+
+	if err != nil || !changed {
+		return false, err
+	}
+
+	p := &api.KVPair{Key: pair.Key, Value: data, Flags: pair.Flags, ModifyIndex: pair.ModifyIndex}
+
+	casSpan := tracer.StartSpanFromContext(ctx, "CAS")
+	ok, _, err := ps.kv(ctx).CAS(p, nil)
+	casSpan.Finish()
+
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, errors.New("config " + pair.Key + " changed during key rotation")
+	}
+
+	ps.recordAudit(ctx, model.AuditRewrap, pair.Key, api.KVPairs{pair}, api.KVPairs{p})
+
+	return true, nil
+}
+
+func (ps *ConfigStore) rewrapConfig(value []byte) ([]byte, bool, error) {
+	config := &model.Config{}
+	if err := json.Unmarshal(value, config); err != nil {
+		return nil, false, err
+	}
+
+	if !config.Secret || config.Envelope == nil {
+		return nil, false, nil
+	}
+
+	rotationScanned.Inc()
+	ps.rotation.update(func(status *model.RotationJSON) {
+		status.Scanned++
+	})
+
+	env, changed, err := ps.keyring.Rewrap(config.Envelope)
+	if err != nil || !changed {
+		return nil, false, err
+	}
+	config.Envelope = env
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return data, true, nil
+}
+
+func (ps *ConfigStore) rewrapTombstone(value []byte) ([]byte, bool, error) {
+	t := &model.TombstoneJSON{}
+	if err := json.Unmarshal(value, t); err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	for i, p := range t.Pairs {
+		data, rewrapped, err := ps.rewrapConfig(p.Value)
+		if err != nil {
+			return nil, false, err
+		}
+		if rewrapped {
+			t.Pairs[i].Value = data
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil, false, nil
+	}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return data, true, nil
+}
+
+func (ps *ConfigStore) rotationFailed(err error) {
+	rotationErrors.Inc()
+	ps.rotation.update(func(status *model.RotationJSON) {
+		status.Errors++
+		status.LastError = err.Error()
+	})
+}
@@ -0,0 +1,87 @@
+package poststore
+
+import (
+	"ars-projekat/auth"
+	model "ars-projekat/model"
+	tracer "ars-projekat/tracer"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var ErrNoKeyring = errors.New("secret configs need a key-encryption key, set SECRETS_KEK_FILE")
+
+// secretAAD is the additional data a secret value is sealed with: the key it
+// is stored at, within its namespace, and its config key. A sealed value
+// copied to another config, version or namespace no longer opens.
+func secretAAD(ctx context.Context, storageKey string, key string) []byte {
+	return []byte(namespacePrefix(NamespaceFromContext(ctx)) + storageKey + key)
+}
+
+// encodeConfig serializes a config for storage at storageKey. Secret values
+// are sealed into an envelope and never stored in the clear.
+func (ps *ConfigStore) encodeConfig(ctx context.Context, storageKey string, key string, value string, secret bool) ([]byte, error) {
+	modified := time.Now().UTC()
+	config := model.Config{
+		Key:      key,
+		Value:    value,
+		Modified: &modified,
+	}
+
+	if secret {
+		if ps.keyring == nil {
+			return nil, ErrNoKeyring
+		}
+
+		env, err := ps.keyring.Seal([]byte(value), secretAAD(ctx, storageKey, key))
+		if err != nil {
+			return nil, err
+		}
+
+		config.Value = ""
+		config.Secret = true
+		config.Envelope = env
+	}
+
+	return json.Marshal(config)
+}
+
+// decodeConfig deserializes a config stored at storageKey for a response.
+// Secret values are decrypted only when the caller in ctx may reveal them and
+// redacted otherwise.
+func (ps *ConfigStore) decodeConfig(ctx context.Context, storageKey string, data []byte) (*model.Config, error) {
+	config := &model.Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	config.Modified = nil
+
+	if !config.Secret {
+		return config, nil
+	}
+
+	span := tracer.StartSpanFromContext(ctx, "OpenSecret")
+	defer span.Finish()
+
+	env := config.Envelope
+	config.Envelope = nil
+	config.Value = model.Redacted
+
+	if !auth.CanReveal(ctx) || env == nil {
+		return config, nil
+	}
+
+	if ps.keyring == nil {
+		return nil, ErrNoKeyring
+	}
+
+	value, err := ps.keyring.Open(env, secretAAD(ctx, storageKey, config.Key))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	config.Value = string(value)
+
+	return config, nil
+}
@@ -0,0 +1,92 @@
+package poststore
+
+import (
+	"ars-projekat/auth"
+	"ars-projekat/model"
+	"ars-projekat/secrets"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newSecretStore(t *testing.T) (*ConfigStore, *fakeConsul) {
+	t.Helper()
+
+	ps, fake := newTestStore(t)
+
+	path := filepath.Join(t.TempDir(), "kek")
+	if err := os.WriteFile(path, []byte(strings.Repeat("k", 32)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := secrets.LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.keyring = keyring
+
+	return ps, fake
+}
+
+func TestSecretBoundToStorageKey(t *testing.T) {
+	ps, fake := newSecretStore(t)
+	ctx := context.Background()
+
+	if err := ps.CreateNamespace(ctx, &model.NamespaceJSON{Name: "team-a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := ps.CreateConfig(ctx, &model.ConfigJSON{Key: "password", Value: "s3cret", Secret: true, Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := fake.pairs[constructConfigKey(id, "1")].Value
+	if strings.Contains(string(stored), "s3cret") {
+		t.Fatal("secret value stored in the clear")
+	}
+
+	fake.set(constructConfigKey(id, "2"), stored, 0)
+	fake.set(namespacePrefix("team-a")+constructConfigKey(id, "1"), stored, 0)
+
+	// an envelope bound to the config key alone opens nowhere
+	env, err := ps.keyring.Seal([]byte("s3cret"), []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unbound, err := json.Marshal(model.Config{Key: "password", Secret: true, Envelope: env})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.set(constructConfigKey(id, "3"), unbound, 0)
+
+	reveal := auth.WithReveal(ctx)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		version string
+		want    string
+		wantErr bool
+	}{
+		{name: "redacted", ctx: ctx, version: "1", want: model.Redacted},
+		{name: "revealed", ctx: reveal, version: "1", want: "s3cret"},
+		{name: "copied to another version", ctx: reveal, version: "2", wantErr: true},
+		{name: "copied to another namespace", ctx: WithNamespace(reveal, "team-a"), version: "1", wantErr: true},
+		{name: "bound to the config key only", ctx: reveal, version: "3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _, err := ps.GetConfig(tt.ctx, id, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && config.Value != tt.want {
+				t.Errorf("GetConfig() = %q, want %q", config.Value, tt.want)
+			}
+		})
+	}
+}
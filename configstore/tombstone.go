@@ -0,0 +1,328 @@
+package poststore
+
+import (
+	"ars-projekat/auth"
+	model "ars-projekat/model"
+	tracer "ars-projekat/tracer"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/hashicorp/consul/api"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var ErrVersionExists = errors.New("a live version already exists")
+
+// tombstonePair builds a new tombstone, which keeps the pairs of a deleted
+// version under tombstones/, so the version can be restored until the
+// retention period is over. Written with a CAS at index 0, it never replaces
+// another one.
+func tombstonePair(ctx context.Context, resource string, pairs api.KVPairs) (*api.KVPair, error) {
+	t := model.TombstoneJSON{
+		Resource:  resource,
+		DeletedAt: time.Now().UTC(),
+	}
+	if identity, ok := auth.IdentityFromContext(ctx); ok {
+		t.DeletedBy = identity.Subject
+	}
+	for _, p := range pairs {
+		t.Pairs = append(t.Pairs, model.TombstonePairJSON{Key: p.Key, Flags: p.Flags, Value: p.Value})
+	}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.KVPair{Key: generateTombstoneKey(resource, t.DeletedAt), Value: data}, nil
+}
+
+// getTombstone returns the latest tombstone of a version, or nil when there
+// is none.
+func (ps *ConfigStore) getTombstone(ctx context.Context, resource string) (*model.TombstoneJSON, *api.KVPair, error) {
+	kv := ps.kv(ctx)
+
+	listSpan := tracer.StartSpanFromContext(ctx, "List")
+	pairs, _, err := kv.List(constructTombstoneKey(resource), nil)
+	listSpan.Finish()
+
+	if err != nil || len(pairs) == 0 {
+		return nil, nil, err
+	}
+
+	pair := pairs[len(pairs)-1]
+
+	t := &model.TombstoneJSON{}
+	if err := json.Unmarshal(pair.Value, t); err != nil {
+		return nil, nil, err
+	}
+
+	return t, pair, nil
+}
+
+func (ps *ConfigStore) GetDeletedConfig(ctx context.Context, id string, version string) (*model.Config, *model.Meta, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetDeletedConfig")
+	defer span.Finish()
+
+	t, pair, err := ps.getTombstone(ctx, constructConfigKey(id, version))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+
+	if t == nil || len(t.Pairs) == 0 {
+		return nil, nil, ErrConfigNotFound
+	}
+
+	config, err := ps.decodeConfig(ctx, t.Pairs[0].Key, t.Pairs[0].Value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := &model.Meta{Index: pair.ModifyIndex, Modified: t.DeletedAt, Deleted: t.DeletedAt}
+
+	return config, meta, nil
+}
+
+func (ps *ConfigStore) GetDeletedGroup(ctx context.Context, id string, version string, labels string) ([]*model.Config, *model.Meta, error) {
+	span := tracer.StartSpanFromContext(ctx, "GetDeletedGroup")
+	defer span.Finish()
+
+	t, pair, err := ps.getTombstone(ctx, constructGroupKey(id, version, ""))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+
+	if t == nil {
+		return nil, nil, ErrGroupNotFound
+	}
+
+	groupKey := constructGroupKey(id, version, labels)
+
+	groupConfigs := []*model.Config{}
+	for _, p := range t.Pairs {
+		if !strings.HasPrefix(p.Key, groupKey) {
+			continue
+		}
+
+		config, err := ps.decodeConfig(ctx, p.Key, p.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		groupConfigs = append(groupConfigs, config)
+	}
+
+	if len(groupConfigs) == 0 {
+		return nil, nil, ErrGroupNotFound
+	}
+
+	meta := &model.Meta{Index: pair.ModifyIndex, Modified: t.DeletedAt, Deleted: t.DeletedAt}
+
+	return groupConfigs, meta, nil
+}
+
+func (ps *ConfigStore) RestoreConfig(ctx context.Context, id string, version string) (map[string]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoreConfig")
+	defer span.Finish()
+
+	if err := ps.restore(ctx, constructConfigKey(id, version), model.RecordConfig, configsPrefix+id+"/", ErrConfigNotFound); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return map[string]string{"Restored": id}, nil
+}
+
+func (ps *ConfigStore) RestoreGroup(ctx context.Context, id string, version string) (map[string]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoreGroup")
+	defer span.Finish()
+
+	if err := ps.restore(ctx, constructGroupKey(id, version, ""), model.RecordGroup, groupsPrefix+id+"/", ErrGroupNotFound); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	return map[string]string{"Restored": id}, nil
+}
+
+// restore writes the pairs of the latest tombstone back and then removes it.
+// A version can hold more pairs than fit in one transaction, so they are
+// written in batches, the first one checking that the tombstone is still
+// there, and the tombstone is only removed once every batch has committed. It
+// refuses to restore when the version has been created again in the
+// meantime, or when the tombstone is gone by then; a version created while
+// later batches are written stops the restore with ErrConflict, keeping the
+// tombstone. Restoring the only version of an id brings the id back, so the
+// first batch also carries the quota check for kind, like a create.
+func (ps *ConfigStore) restore(ctx context.Context, resource string, kind string, idPrefix string, notFound error) error {
+	kv := ps.kv(ctx)
+
+	t, tombstonePair, err := ps.getTombstone(ctx, resource)
+	if err != nil {
+		return err
+	}
+
+	if t == nil {
+		return notFound
+	}
+
+	keysSpan := tracer.StartSpanFromContext(ctx, "Keys")
+	live, _, err := kv.Keys(idPrefix, "", nil)
+	keysSpan.Finish()
+
+	if err != nil {
+		return err
+	}
+
+	for _, key := range live {
+		if strings.HasPrefix(key, resource) {
+			return ErrVersionExists
+		}
+	}
+
+	restored := api.KVPairs{}
+	ops := api.KVTxnOps{&api.KVTxnOp{Verb: api.KVCheckIndex, Key: tombstonePair.Key, Index: tombstonePair.ModifyIndex}}
+	if len(live) == 0 {
+		guard, err := ps.checkQuota(ctx, kind)
+		if err != nil {
+			return err
+		}
+		if guard != nil {
+			ops = append(ops, guard)
+		}
+	}
+	checks := len(ops)
+	for _, p := range t.Pairs {
+		restored = append(restored, &api.KVPair{Key: p.Key, Flags: p.Flags, Value: p.Value})
+		// index 0 only writes keys that do not exist
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVCAS, Key: p.Key, Flags: p.Flags, Value: p.Value, Index: 0})
+	}
+
+	committed, ok, resp, err := ps.commitBatches(ctx, ops)
+
+	// the pairs written before a failed batch are back all the same
+	if committed > checks {
+		ps.recordAudit(ctx, model.AuditRestore, resource, nil, restored[:committed-checks])
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		for _, e := range resp.Errors {
+			if e.OpIndex == 0 {
+				return notFound
+			}
+		}
+		// the quota guard failing means another create got in first
+		if committed > 0 || len(resp.Errors) > 0 && resp.Errors[0].OpIndex < checks {
+			return ErrConflict
+		}
+		return ErrVersionExists
+	}
+
+	// A purge that got to the tombstone since leaves nothing to remove.
+	deleteOps := api.KVTxnOps{&api.KVTxnOp{Verb: api.KVDeleteCAS, Key: tombstonePair.Key, Index: tombstonePair.ModifyIndex}}
+	if _, _, err := ps.commit(ctx, deleteOps); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PurgeTombstones removes the tombstones of versions deleted longer than the
+// retention period ago, in every namespace, and returns how many it removed.
+func (ps *ConfigStore) PurgeTombstones(ctx context.Context) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "PurgeTombstones")
+	defer span.Finish()
+
+	ctx = tracer.ContextWithSpan(ctx, span)
+
+	purged := 0
+	err := ps.eachNamespace(ctx, func(ctx context.Context) error {
+		n, err := ps.purgeTombstones(ctx)
+		purged += n
+		return err
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+	}
+
+	return purged, err
+}
+
+func (ps *ConfigStore) purgeTombstones(ctx context.Context) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "purgeTombstones")
+	defer span.Finish()
+
+	span.SetTag("namespace", NamespaceFromContext(ctx))
+
+	kv := ps.kv(ctx)
+
+	listSpan := tracer.StartSpanFromContext(ctx, "List")
+	data, _, err := kv.List(tombstonesPrefix, nil)
+	listSpan.Finish()
+
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-ps.retention)
+	purged := 0
+	for _, pair := range data {
+		t := &model.TombstoneJSON{}
+		if err := json.Unmarshal(pair.Value, t); err != nil {
+			tracer.LogError(span, err)
+			continue
+		}
+
+		if t.DeletedAt.After(cutoff) {
+			continue
+		}
+
+		deleteSpan := tracer.StartSpanFromContext(ctx, "DeleteCAS")
+		ok, _, err := kv.DeleteCAS(pair, nil)
+		deleteSpan.Finish()
+
+		if err != nil {
+			tracer.LogError(span, err)
+			return purged, err
+		}
+
+		if ok {
+			purged++
+			ps.recordAudit(ctx, model.AuditPurge, t.Resource, api.KVPairs{pair}, nil)
+		}
+	}
+
+	return purged, nil
+}
+
+// RunPurge purges expired tombstones every purge interval until ctx is done.
+func (ps *ConfigStore) RunPurge(ctx context.Context) {
+	ticker := time.NewTicker(ps.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			span := tracer.StartSpanFromContext(ctx, "RunPurge")
+			purgeCtx := tracer.ContextWithSpan(ctx, span)
+
+			purged, err := ps.PurgeTombstones(purgeCtx)
+			if err != nil {
+				ps.logger.ErrorContext(purgeCtx, "purging tombstones failed", slog.Int("purged", purged), slog.Any("error", err))
+			} else if purged > 0 {
+				ps.logger.InfoContext(purgeCtx, "purged tombstones", slog.Int("purged", purged))
+			}
+			span.Finish()
+		}
+	}
+}
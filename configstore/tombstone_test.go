@@ -0,0 +1,91 @@
+package poststore
+
+import (
+	"ars-projekat/model"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTombstonesOfRecreatedVersion(t *testing.T) {
+	ps, fake := newTestStore(t)
+	ctx := context.Background()
+
+	id, err := ps.CreateConfig(ctx, &model.ConfigJSON{Key: "k", Value: "first", Version: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.CreateConfigVersion(ctx, id, &model.ConfigJSON{Key: "k", Value: "other", Version: "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ps.DeleteConfig(ctx, id, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.CreateConfigVersion(ctx, id, &model.ConfigJSON{Key: "k", Value: "second", Version: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.DeleteConfig(ctx, id, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := fake.keys(constructTombstoneKey(constructConfigKey(id, "1"))); len(keys) != 2 {
+		t.Fatalf("tombstones = %v, want one per deletion", keys)
+	}
+
+	config, _, err := ps.GetDeletedConfig(ctx, id, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Value != "second" {
+		t.Errorf("GetDeletedConfig() = %q, want the latest deletion", config.Value)
+	}
+
+	if _, err := ps.RestoreConfig(ctx, id, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.RestoreConfig(ctx, id, "1"); !errors.Is(err, ErrVersionExists) {
+		t.Errorf("RestoreConfig() of a live version = %v, want %v", err, ErrVersionExists)
+	}
+
+	config, _, err = ps.GetConfig(ctx, id, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Value != "second" {
+		t.Errorf("restored value = %q, want %q", config.Value, "second")
+	}
+
+	config, _, err = ps.GetDeletedConfig(ctx, id, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Value != "first" {
+		t.Errorf("GetDeletedConfig() after restore = %q, want the earlier deletion", config.Value)
+	}
+}
+
+func TestRestoreLargeGroup(t *testing.T) {
+	ps, fake := newTestStore(t)
+	ctx := context.Background()
+
+	group := largeGroup("1")
+	id, err := ps.CreateGroup(ctx, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.DeleteGroup(ctx, id, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ps.RestoreGroup(ctx, id, "1"); err != nil {
+		t.Fatalf("RestoreGroup() error = %v", err)
+	}
+
+	if live := fake.keys(constructGroupKey(id, "1", "")); len(live) != len(group.Configs) {
+		t.Errorf("%d configs restored, want %d", len(live), len(group.Configs))
+	}
+	if tombstones := fake.keys(constructTombstoneKey(constructGroupKey(id, "1", ""))); len(tombstones) != 0 {
+		t.Errorf("tombstones after restore = %v, want none", tombstones)
+	}
+}
@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"ars-projekat/config"
+	"ars-projekat/model"
+	tracer "ars-projekat/tracer"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// New returns a logger writing JSON lines to stdout, and the level it logs
+// at, which starts at cfg.Level and can be changed while the service runs.
+func New(cfg config.LoggingConfig) (*slog.Logger, *slog.LevelVar, error) {
+	level := &slog.LevelVar{}
+	if err := SetLevel(level, cfg.Level); err != nil {
+		return nil, nil, fmt.Errorf("log level: %w", err)
+	}
+
+	return slog.New(NewHandler(os.Stdout, level)), level, nil
+}
+
+// NewHandler returns a JSON handler that adds the trace, span and request id
+// found in the context to every record.
+func NewHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})}
+}
+
+// SetLevel parses a level name, such as debug or WARN, into level.
+func SetLevel(level *slog.LevelVar, name string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return err
+	}
+
+	level.Set(l)
+	return nil
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := tracer.TraceID(ctx); id != "" {
+		r.AddAttrs(slog.String("trace_id", id), slog.String("span_id", tracer.SpanID(ctx)))
+	}
+	if id := model.RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"ars-projekat/auth"
+	"ars-projekat/certs"
+	"ars-projekat/config"
 	poststore "ars-projekat/configstore"
+	"ars-projekat/logging"
 	tracer "ars-projekat/tracer"
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"github.com/gorilla/mux"
 	"github.com/opentracing/opentracing-go"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -16,58 +25,196 @@ import (
 
 func main() {
 	//komentar
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger, logLevel, err := logging.New(cfg.Logging)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
 	router := mux.NewRouter()
 	router.StrictSlash(true)
 
-	store, err := poststore.New()
+	store, err := poststore.New(cfg.Storage, logger)
 	if err != nil {
-		log.Fatal(err)
+		fatal(logger, err)
 	}
 
-	tracer, closer := tracer.Init("config_service")
+	authenticator, err := auth.New()
+	if err != nil {
+		fatal(logger, err)
+	}
+	if !authenticator.Configured() {
+		logger.Warn("no credentials configured, every request will be rejected")
+	}
+
+	tracer, closer := tracer.Init(cfg.Tracing)
 	opentracing.SetGlobalTracer(tracer)
 
+	var reloader *certs.Reloader
+	clientAuth := tls.NoClientCert
+	if cfg.Server.TLS.Enabled() {
+		reloader, err = certs.NewReloader(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile, cfg.Server.TLS.ClientCAFile, logger)
+		if err != nil {
+			fatal(logger, err)
+		}
+		clientAuth, err = certs.ClientAuth(cfg.Server.TLS.ClientAuth)
+		if err != nil {
+			fatal(logger, err)
+		}
+		if clientAuth != tls.NoClientCert {
+			authenticator.TrustClientCertificates()
+		}
+	}
+
 	server := Service{
-		store:  store,
-		tracer: tracer,
-		closer: closer,
+		store:    store,
+		tracer:   tracer,
+		closer:   closer,
+		auth:     authenticator,
+		logger:   logger,
+		logLevel: logLevel,
+
+		maxBodyBytes: cfg.Server.MaxBodyBytes,
+	}
+
+	router.Use(requestID)
+	router.Use(instrument)
+	router.Use(server.logRequests)
+	// the router runs its middleware for matched routes only, so the handlers
+	// answering unmatched requests are wrapped in the same chain, to get 404s
+	// and 405s logged and counted under the "unmatched" route
+	router.NotFoundHandler = requestID(instrument(server.logRequests(http.NotFoundHandler())))
+	router.MethodNotAllowedHandler = requestID(instrument(server.logRequests(http.HandlerFunc(methodNotAllowed))))
+
+	server.configRoutes(router, func(handlerFunc func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+		return handlerFunc
+	})
+	server.configRoutes(router.PathPrefix("/ns/{ns}").Subrouter(), server.namespaced)
+	router.HandleFunc("/admin/namespaces/", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.createNamespaceHandler))).Methods("POST")
+	router.HandleFunc("/admin/namespaces/", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.getNamespacesHandler))).Methods("GET")
+	router.HandleFunc("/admin/namespaces/{ns}/", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.getNamespaceHandler))).Methods("GET")
+	router.HandleFunc("/admin/namespaces/{ns}/quota", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.setNamespaceQuotaHandler))).Methods("PUT")
+	router.HandleFunc("/admin/export", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.exportHandler))).Methods("GET")
+	router.HandleFunc("/admin/import", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.importHandler))).Methods("POST")
+	router.HandleFunc("/admin/policies/", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.IdempotencyCheck(server.createPolicyHandler)))).Methods("POST")
+	router.HandleFunc("/admin/policies/", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.getPoliciesHandler))).Methods("GET")
+	router.HandleFunc("/admin/policies/{uuid}/", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.delPolicyHandler))).Methods("DELETE")
+	router.HandleFunc("/audit", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.getAuditHandler))).Methods("GET")
+	router.HandleFunc("/admin/keys/rotate", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.rotateKeysHandler))).Methods("POST")
+	router.HandleFunc("/admin/keys/rotate", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.getKeyRotationHandler))).Methods("GET")
+	router.HandleFunc("/admin/log-level", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.getLogLevelHandler))).Methods("GET")
+	router.HandleFunc("/admin/log-level", server.authenticate(server.authorize(auth.ActionAdmin, auth.ResourceAdmin, server.setLogLevelHandler))).Methods("PUT")
+	router.HandleFunc("/healthz", server.healthzHandler).Methods("GET")
+	router.HandleFunc("/readyz", server.readyzHandler).Methods("GET")
+	if cfg.Metrics.Enabled {
+		router.Path(cfg.Metrics.Path).Handler(metricsHandler())
+	}
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go store.RunPurge(backgroundCtx)
+	go store.RunInventory(backgroundCtx)
+	if reloader != nil && cfg.Server.TLS.ReloadInterval > 0 {
+		go reloader.Watch(backgroundCtx, cfg.Server.TLS.ReloadInterval)
 	}
 
-	router.HandleFunc("/configs/", count(server.IdempotencyCheck(server.createConfigHandler), "createConfigHandler")).Methods("POST")
-	router.HandleFunc("/configs/{uuid}/", count(server.IdempotencyCheck(server.createConfigVersionHandler), "createConfigVersionHandler")).Methods("POST")
-	router.HandleFunc("/groups/", count(server.IdempotencyCheck(server.createGroupHandler), "createGroupHandler")).Methods("POST")
-	router.HandleFunc("/groups/{uuid}/", count(server.IdempotencyCheck(server.createGroupVersionHandler), "createGroupVersionHandler")).Methods("POST")
-	router.HandleFunc("/configs/{uuid}/{ver}/", count(server.getConfigHandler, "getConfigHandler")).Methods("GET")
-	router.HandleFunc("/groups/{uuid}/{ver}/", count(server.getGroupHandler, "getGroupHandler")).Methods("GET")
-	router.HandleFunc("/configs/{uuid}/{ver}/", count(server.delConfigHandler, "delConfigHandler")).Methods("DELETE")
-	router.HandleFunc("/groups/{uuid}/{ver}/", count(server.delGroupHandler, "delGroupHandler")).Methods("DELETE")
-	router.HandleFunc("/groups/{uuid}/{ver}/configs/", count(server.IdempotencyCheck(server.addConfigToGroupHandler), "addConfigToGroupHandler")).Methods("POST")
-	router.Path("/metrics").Handler(metricsHandler())
+	// requests derive their context from baseCtx, so cancelling it aborts the
+	// store calls still running when shutdown gives up waiting
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 
 	// start server
-	srv := &http.Server{Addr: "0.0.0.0:8000", Handler: router}
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+	if reloader != nil {
+		// the error log also ends the spans of failed handshakes
+		srv.TLSConfig, srv.ErrorLog = reloader.ServerConfig(clientAuth, tracer)
+	}
 	go func() {
-		log.Println("server starting")
-		if err := srv.ListenAndServe(); err != nil {
+		logger.Info("server starting", slog.String("addr", srv.Addr), slog.Bool("tls", cfg.Server.TLS.Enabled()))
+
+		var err error
+		if reloader != nil {
+			// the certificates come from srv.TLSConfig
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil {
 			if err != http.ErrServerClosed {
-				log.Fatal(err)
+				fatal(logger, err)
 			}
 		}
 	}()
 
 	<-quit
 
-	log.Println("service shutting down ...")
+	logger.Info("service shutting down")
+	server.shuttingDown.Store(true)
+	stopBackground()
+
+	// give load balancers time to see /readyz fail before connections are
+	// refused
+	if drain := cfg.Server.DrainDelay; drain > 0 {
+		logger.Info("draining", slog.Duration("delay", drain))
+		time.Sleep(drain)
+	}
 
 	// gracefully stop server
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal(err)
+		logger.Warn("graceful shutdown failed", slog.Any("error", err))
+		cancelRequests()
+		srv.Close()
+	}
+
+	// flush the spans that are still buffered
+	if err := closer.Close(); err != nil {
+		logger.Warn("flushing traces failed", slog.Any("error", err))
 	}
-	log.Println("server stopped")
+	logger.Info("server stopped")
+}
+
+// configRoutes registers the config and group endpoints on router. scope wraps
+// each handler once the request is authorized, to bind it to a namespace.
+func (ts *Service) configRoutes(router *mux.Router, scope func(func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request)) {
+	router.HandleFunc("/configs/", ts.authenticate(ts.authorize(auth.ActionWrite, auth.ResourceConfig, scope(ts.IdempotencyCheck(ts.createConfigHandler))))).Methods("POST")
+	router.HandleFunc("/configs/{uuid}/", ts.authenticate(ts.authorize(auth.ActionWrite, auth.ResourceConfig, scope(ts.IdempotencyCheck(ts.createConfigVersionHandler))))).Methods("POST")
+	router.HandleFunc("/groups/", ts.authenticate(ts.authorize(auth.ActionWrite, auth.ResourceGroup, scope(ts.IdempotencyCheck(ts.createGroupHandler))))).Methods("POST")
+	router.HandleFunc("/groups/{uuid}/", ts.authenticate(ts.authorize(auth.ActionWrite, auth.ResourceGroup, scope(ts.IdempotencyCheck(ts.createGroupVersionHandler))))).Methods("POST")
+	router.HandleFunc("/configs/{uuid}/{ver}/", ts.authenticate(ts.authorize(auth.ActionRead, auth.ResourceConfig, scope(ts.getConfigHandler)))).Methods("GET")
+	router.HandleFunc("/groups/{uuid}/{ver}/", ts.authenticate(ts.authorize(auth.ActionRead, auth.ResourceGroup, scope(ts.getGroupHandler)))).Methods("GET")
+	router.HandleFunc("/configs/{uuid}/", ts.authenticate(ts.authorize(auth.ActionDelete, auth.ResourceConfig, scope(ts.delConfigVersionsHandler)))).Methods("DELETE")
+	router.HandleFunc("/groups/{uuid}/", ts.authenticate(ts.authorize(auth.ActionDelete, auth.ResourceGroup, scope(ts.delGroupVersionsHandler)))).Methods("DELETE")
+	router.HandleFunc("/configs/{uuid}/{ver}/", ts.authenticate(ts.authorize(auth.ActionDelete, auth.ResourceConfig, scope(ts.delConfigHandler)))).Methods("DELETE")
+	router.HandleFunc("/groups/{uuid}/{ver}/", ts.authenticate(ts.authorize(auth.ActionDelete, auth.ResourceGroup, scope(ts.delGroupHandler)))).Methods("DELETE")
+	router.HandleFunc("/configs/{uuid}/{ver}/restore", ts.authenticate(ts.authorize(auth.ActionDelete, auth.ResourceConfig, scope(ts.restoreConfigHandler)))).Methods("POST")
+	router.HandleFunc("/groups/{uuid}/{ver}/restore", ts.authenticate(ts.authorize(auth.ActionDelete, auth.ResourceGroup, scope(ts.restoreGroupHandler)))).Methods("POST")
+	router.HandleFunc("/groups/{uuid}/{ver}/configs/", ts.authenticate(ts.authorize(auth.ActionWrite, auth.ResourceGroup, scope(ts.IdempotencyCheck(ts.addConfigToGroupHandler))))).Methods("POST")
+}
+
+func fatal(logger *slog.Logger, err error) {
+	logger.Error(err.Error())
+	os.Exit(1)
 }
@@ -1,19 +1,55 @@
 package main
 
 import (
+	poststore "ars-projekat/configstore"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 var (
 	// Prometheus Registry to register metrics.
 	prometheusRegistry = prometheus.NewRegistry()
 
-	totalHits = prometheus.NewCounter(
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Duration of http requests, by route template, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"route", "method", "code"},
+	)
+
+	requestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "my_app_http_hit_total",
-			Help: "Total number of http hits.",
+			Name: "http_requests_total",
+			Help: "Total number of http requests, by route template, method and status code.",
+		},
+		[]string{"route", "method", "code"},
+	)
+
+	requestsInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of http requests currently being served, by route template.",
+		},
+		[]string{"route"},
+	)
+
+	idempotencyHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "idempotency_cache_hits_total",
+			Help: "Total number of requests answered from the idempotency cache.",
+		},
+	)
+
+	idempotencyMisses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "idempotency_cache_misses_total",
+			Help: "Total number of requests whose idempotency key was not cached.",
 		},
 	)
 )
@@ -23,25 +59,71 @@ func metricsHandler() http.Handler {
 }
 
 func init() {
-	prometheusRegistry.MustRegister(totalHits)
+	prometheusRegistry.MustRegister(requestDuration, requestsTotal, requestsInFlight)
+	prometheusRegistry.MustRegister(idempotencyHits, idempotencyMisses)
+	prometheusRegistry.MustRegister(poststore.Collectors()...)
 }
 
-func count(f func(http.ResponseWriter, *http.Request), name string) func(http.ResponseWriter, *http.Request) {
-	httpHits := prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: name,
-			Help: "Total number of http hits.",
-		},
-	)
+// instrument records the latency, status code and concurrency of every
+// request. Requests are labelled with the template of the route they
+// matched rather than their path, which would give every uuid its own series.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := routeTemplate(req)
 
-	err := prometheusRegistry.Register(httpHits)
-	if err != nil {
-		return f
+		inFlight := requestsInFlight.WithLabelValues(route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		next.ServeHTTP(rec, req)
+
+		code := strconv.Itoa(rec.status)
+		requestDuration.WithLabelValues(route, req.Method, code).Observe(time.Since(start).Seconds())
+		requestsTotal.WithLabelValues(route, req.Method, code).Inc()
+	})
+}
+
+func routeTemplate(req *http.Request) string {
+	if r := mux.CurrentRoute(req); r != nil {
+		if tpl, err := r.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+
+	return "unmatched"
+}
+
+// statusRecorder remembers the status code written by the handler. It keeps
+// http.Flusher working, since NDJSON responses are flushed line by line.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
 	}
+	r.ResponseWriter.WriteHeader(status)
+}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		httpHits.Inc()
-		totalHits.Inc()
-		f(w, r)
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		r.wroteHeader = true
+		flusher.Flush()
 	}
 }
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
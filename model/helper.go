@@ -5,22 +5,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/BurntSushi/toml"
 	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
 	"io"
-	"net/http"
+	"regexp"
 	"sort"
 	"strings"
 )
 
-func DecodeConfig(ctx context.Context, r io.Reader) (*ConfigJSON, error) {
+func DecodeConfig(ctx context.Context, r io.Reader, mediatype string) (*ConfigJSON, error) {
 	span := tracer.StartSpanFromContext(ctx, "DecodeConfig")
 	defer span.Finish()
 
-	dec := json.NewDecoder(r)
-	dec.DisallowUnknownFields()
-
 	var rt ConfigJSON
-	if err := dec.Decode(&rt); err != nil {
+	if err := decodeBody(r, mediatype, &rt); err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
@@ -28,41 +27,166 @@ func DecodeConfig(ctx context.Context, r io.Reader) (*ConfigJSON, error) {
 	return &rt, nil
 }
 
-func DecodeGroupConfig(ctx context.Context, r io.Reader) (*GroupConfigJSON, error) {
+func DecodeGroupConfig(ctx context.Context, r io.Reader, mediatype string) (*GroupConfigJSON, error) {
 	span := tracer.StartSpanFromContext(ctx, "DecodeGroupConfig")
 	defer span.Finish()
 
-	dec := json.NewDecoder(r)
-	dec.DisallowUnknownFields()
-
 	var rt GroupConfigJSON
-	if err := dec.Decode(&rt); err != nil {
+	if err := decodeBody(r, mediatype, &rt); err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 	return &rt, nil
 }
 
-func DecodeGroup(ctx context.Context, r io.Reader) (*GroupJSON, error) {
+func DecodeGroup(ctx context.Context, r io.Reader, mediatype string) (*GroupJSON, error) {
 	span := tracer.StartSpanFromContext(ctx, "DecodeGroup")
 	defer span.Finish()
 
-	dec := json.NewDecoder(r)
-	dec.DisallowUnknownFields()
-
 	var rt GroupJSON
-	if err := dec.Decode(&rt); err != nil {
+	if err := decodeBody(r, mediatype, &rt); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return &rt, nil
+}
+
+func DecodePolicy(ctx context.Context, r io.Reader, mediatype string) (*PolicyJSON, error) {
+	span := tracer.StartSpanFromContext(ctx, "DecodePolicy")
+	defer span.Finish()
+
+	var rt PolicyJSON
+	if err := decodeBody(r, mediatype, &rt); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return &rt, nil
+}
+
+func DecodeLogLevel(ctx context.Context, r io.Reader, mediatype string) (*LogLevelJSON, error) {
+	span := tracer.StartSpanFromContext(ctx, "DecodeLogLevel")
+	defer span.Finish()
+
+	var rt LogLevelJSON
+	if err := decodeBody(r, mediatype, &rt); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return &rt, nil
+}
+
+func DecodeNamespace(ctx context.Context, r io.Reader, mediatype string) (*NamespaceJSON, error) {
+	span := tracer.StartSpanFromContext(ctx, "DecodeNamespace")
+	defer span.Finish()
+
+	var rt NamespaceJSON
+	if err := decodeBody(r, mediatype, &rt); err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 	return &rt, nil
 }
 
+var namespaceName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidNamespace reports whether name can name a namespace: lowercase letters,
+// digits and inner dashes, at most 63 characters, so it is safe in a key and
+// in a URL path.
+func ValidNamespace(name string) bool {
+	return namespaceName.MatchString(name)
+}
+
+var bodyMediaTypes = []string{
+	"application/json",
+	"application/yaml",
+	"application/x-yaml",
+	"text/yaml",
+	"application/toml",
+}
+
+func IsSupportedBodyType(mediatype string) bool {
+	return containsString(bodyMediaTypes, mediatype)
+}
+
+// decodeBody decodes a request body of the given media type into v. Every
+// decoder rejects fields that v does not declare.
+func decodeBody(r io.Reader, mediatype string, v interface{}) error {
+	switch mediatype {
+	case "application/json":
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		return dec.Decode(v)
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		dec := yaml.NewDecoder(r)
+		dec.KnownFields(true)
+		return dec.Decode(v)
+	case "application/toml":
+		md, err := toml.NewDecoder(r).Decode(v)
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("toml: unknown field %q", undecoded[0].String())
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported media type %q", mediatype)
+}
+
+func DecodeRecords(ctx context.Context, r io.Reader) ([]*RecordJSON, error) {
+	span := tracer.StartSpanFromContext(ctx, "DecodeRecords")
+	defer span.Finish()
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	records := []*RecordJSON{}
+	for {
+		var rt RecordJSON
+		err := dec.Decode(&rt)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+
+		if rt.Kind != RecordConfig && rt.Kind != RecordGroup {
+			err := fmt.Errorf("record %d: unknown kind %q", len(records)+1, rt.Kind)
+			tracer.LogError(span, err)
+			return nil, err
+		}
+
+		if rt.Namespace != "" && !ValidNamespace(rt.Namespace) {
+			err := fmt.Errorf("record %d: invalid namespace %q", len(records)+1, rt.Namespace)
+			tracer.LogError(span, err)
+			return nil, err
+		}
+
+		records = append(records, &rt)
+	}
+
+	return records, nil
+}
+
+// reservedQueryParams are query parameters that control the response rather
+// than filter by label.
+var reservedQueryParams = map[string]bool{
+	"format":         true,
+	"pretty":         true,
+	"includeDeleted": true,
+}
+
 func DecodeQueryLabels(labelsMap map[string][]string) string {
 	keys := make([]string, 0, len(labelsMap))
 	pairs := make([]string, 0, len(labelsMap))
 
 	for k := range labelsMap {
+		if reservedQueryParams[k] {
+			continue
+		}
 		keys = append(keys, k)
 	}
 
@@ -99,19 +223,23 @@ func DecodeJSONLabels(ctx context.Context, labels []LabelJSON) string {
 	return strings.Join(pairs[:], "&")
 }
 
-func RenderJSON(ctx context.Context, w http.ResponseWriter, v interface{}) {
-	span := tracer.StartSpanFromContext(ctx, "RenderJSON")
-	defer span.Finish()
+func ParseLabels(labels string) []LabelJSON {
+	if labels == "" {
+		return nil
+	}
 
-	js, err := json.Marshal(v)
-	if err != nil {
-		tracer.LogError(span, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	pairs := strings.Split(labels, "&")
+	result := make([]LabelJSON, 0, len(pairs))
+	for _, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		l := LabelJSON{Key: kv[0]}
+		if len(kv) == 2 {
+			l.Value = kv[1]
+		}
+		result = append(result, l)
 	}
 
-	w.Header().Set("Content-Type", "application/model")
-	w.Write(js)
+	return result
 }
 
 func CreateId() string {
@@ -1,23 +1,125 @@
 package model
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type LabelJSON struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key   string `json:"key" yaml:"key" toml:"key"`
+	Value string `json:"value" yaml:"value" toml:"value"`
 }
 
 type ConfigJSON struct {
-	Key     string `json:"key"`
-	Value   string `json:"value"`
-	Version string `json:"version"`
+	Key     string `json:"key" yaml:"key" toml:"key"`
+	Value   string `json:"value" yaml:"value" toml:"value"`
+	Secret  bool   `json:"secret" yaml:"secret" toml:"secret"`
+	Version string `json:"version" yaml:"version" toml:"version"`
 }
 
 type GroupConfigJSON struct {
-	Key    string      `json:"key"`
-	Value  string      `json:"value"`
-	Labels []LabelJSON `json:"labels"`
+	Key    string      `json:"key" yaml:"key" toml:"key"`
+	Value  string      `json:"value" yaml:"value" toml:"value"`
+	Secret bool        `json:"secret" yaml:"secret" toml:"secret"`
+	Labels []LabelJSON `json:"labels" yaml:"labels" toml:"labels"`
 }
 
 type GroupJSON struct {
-	Configs []GroupConfigJSON `json:"configs"`
-	Version string            `json:"version"`
+	Configs []GroupConfigJSON `json:"configs" yaml:"configs" toml:"configs"`
+	Version string            `json:"version" yaml:"version" toml:"version"`
+}
+
+// RecordJSON is one exported config. Namespace is empty for the data outside
+// of every namespace.
+type RecordJSON struct {
+	Namespace string      `json:"namespace,omitempty"`
+	Kind      string      `json:"kind"`
+	Id        string      `json:"id"`
+	Version   string      `json:"version"`
+	ConfigId  string      `json:"configId,omitempty"`
+	Labels    []LabelJSON `json:"labels,omitempty"`
+	Config    Config      `json:"config"`
+}
+
+type PolicyJSON struct {
+	Id        string      `json:"id"`
+	Subject   string      `json:"subject"`
+	Role      string      `json:"role"`
+	Namespace string      `json:"namespace,omitempty"`
+	Configs   []string    `json:"configs,omitempty"`
+	Groups    []string    `json:"groups,omitempty"`
+	Labels    []LabelJSON `json:"labels,omitempty"`
+}
+
+type AuditJSON struct {
+	Id        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	RequestId string    `json:"requestId,omitempty"`
+	TraceId   string    `json:"traceId,omitempty"`
+	Action    string    `json:"action"`
+	Resource  string    `json:"resource"`
+	Before    string    `json:"before,omitempty"`
+	After     string    `json:"after,omitempty"`
+}
+
+type RotationJSON struct {
+	KeyId      string     `json:"keyId"`
+	InProgress bool       `json:"inProgress"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Scanned    int        `json:"scanned"`
+	Rewrapped  int        `json:"rewrapped"`
+	Errors     int        `json:"errors"`
+	LastError  string     `json:"lastError,omitempty"`
+}
+
+type TombstoneJSON struct {
+	Resource  string              `json:"resource"`
+	DeletedAt time.Time           `json:"deletedAt"`
+	DeletedBy string              `json:"deletedBy,omitempty"`
+	Pairs     []TombstonePairJSON `json:"pairs"`
+}
+
+type TombstonePairJSON struct {
+	Key   string          `json:"key"`
+	Flags uint64          `json:"flags,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+type DeletedJSON struct {
+	Deleted  string   `json:"Deleted"`
+	Versions []string `json:"Versions,omitempty"`
+	Configs  int      `json:"Configs,omitempty"`
+}
+
+// NamespaceJSON describes a namespace. Usage is filled in when it is read.
+type NamespaceJSON struct {
+	Name    string     `json:"name" yaml:"name" toml:"name"`
+	Quota   QuotaJSON  `json:"quota" yaml:"quota" toml:"quota"`
+	Created time.Time  `json:"created" yaml:"-" toml:"-"`
+	Usage   *QuotaJSON `json:"usage,omitempty" yaml:"-" toml:"-"`
+}
+
+// QuotaJSON limits how many configs and groups a namespace holds, counting
+// ids rather than versions. Zero means no limit.
+type QuotaJSON struct {
+	Configs int `json:"configs" yaml:"configs" toml:"configs"`
+	Groups  int `json:"groups" yaml:"groups" toml:"groups"`
+}
+
+type LogLevelJSON struct {
+	Level string `json:"level" yaml:"level" toml:"level"`
+}
+
+type HealthJSON struct {
+	Status string                     `json:"status"`
+	Checks map[string]HealthCheckJSON `json:"checks,omitempty"`
+}
+
+// HealthCheckJSON is the state of one dependency. Latency is in seconds.
+type HealthCheckJSON struct {
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency,omitempty"`
+	Error   string  `json:"error,omitempty"`
 }
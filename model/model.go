@@ -1,6 +1,76 @@
 package model
 
+import (
+	"ars-projekat/secrets"
+	"time"
+)
+
 type Config struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Secret bool   `json:"secret,omitempty"`
+	// Envelope holds the encrypted value of a secret config in storage. It is
+	// never part of a response.
+	Envelope *secrets.Envelope `json:"envelope,omitempty"`
+	// Modified is the time the config was written. Like Envelope it is only
+	// kept in storage.
+	Modified *time.Time `json:"modified,omitempty"`
+}
+
+// Redacted replaces the value of secret configs the caller may not see.
+const Redacted = "[REDACTED]"
+
+const (
+	RecordConfig = "config"
+	RecordGroup  = "group"
+)
+
+// Meta describes the stored state a response was built from.
+type Meta struct {
+	Index    uint64
+	Modified time.Time
+	// Deleted is set when the response shows a deleted version.
+	Deleted time.Time
 }
+
+// Merge folds other into m, keeping the most recent index and time.
+func (m *Meta) Merge(other *Meta) {
+	if other.Index > m.Index {
+		m.Index = other.Index
+	}
+	if other.Modified.After(m.Modified) {
+		m.Modified = other.Modified
+	}
+}
+
+const (
+	AuditCreate     = "create"
+	AuditVersion    = "version"
+	AuditAddToGroup = "add-to-group"
+	AuditDelete     = "delete"
+	AuditImport     = "import"
+	AuditRewrap     = "rewrap"
+	AuditRestore    = "restore"
+	AuditPurge      = "purge"
+	AuditUpdate     = "update"
+)
+
+// AuditFilter narrows an audit log query. Zero fields match everything and
+// Resource matches as a key prefix. Limit bounds the entries of a page, which
+// continues after the entry the cursor After names.
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Resource string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	After    string
+}
+
+const (
+	HealthOK           = "ok"
+	HealthFailing      = "failing"
+	HealthDisabled     = "disabled"
+	HealthShuttingDown = "shutting_down"
+)
@@ -0,0 +1,525 @@
+package model
+
+import (
+	tracer "ars-projekat/tracer"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type groupFormat struct {
+	name      string
+	mediaType string
+	aliases   []string
+	encode    func(w io.Writer, configs []*Config) error
+}
+
+// groupFormats lists the representations a config group can be rendered in,
+// in order of preference when the client accepts several equally. The first
+// entry doubles as the only representation of every other response.
+var groupFormats = []groupFormat{
+	{name: "json", mediaType: "application/json", encode: encodeGroupJSON},
+	{name: "yaml", mediaType: "application/yaml", aliases: []string{"application/x-yaml", "text/yaml"}, encode: encodeGroupYAML},
+	{name: "toml", mediaType: "application/toml", encode: encodeGroupTOML},
+	{name: "properties", mediaType: "text/x-java-properties", encode: encodeGroupProperties},
+	{name: "dotenv", mediaType: "text/x-dotenv", aliases: []string{"env"}, encode: encodeGroupDotenv},
+}
+
+var jsonFormats = groupFormats[:1]
+
+const ndjsonMediaType = "application/x-ndjson"
+
+func RenderJSON(ctx context.Context, w http.ResponseWriter, req *http.Request, v interface{}) {
+	Render(ctx, w, req, v, nil)
+}
+
+// RenderStatus writes v as JSON with a status other than 200 OK.
+func RenderStatus(ctx context.Context, w http.ResponseWriter, req *http.Request, status int, v interface{}) {
+	span := tracer.StartSpanFromContext(ctx, "RenderStatus")
+	defer span.Finish()
+
+	f, code, err := negotiateFormat(req, jsonFormats)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeBody(w, req, f, js, nil, status)
+}
+
+// Render writes v as JSON. When meta is given the response carries ETag and
+// Last-Modified headers describing the stored value, and a matching
+// If-None-Match is answered with 304 Not Modified.
+func Render(ctx context.Context, w http.ResponseWriter, req *http.Request, v interface{}, meta *Meta) {
+	span := tracer.StartSpanFromContext(ctx, "Render")
+	defer span.Finish()
+
+	f, status, err := negotiateFormat(req, jsonFormats)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeBody(w, req, f, js, meta, http.StatusOK)
+}
+
+// RenderGroup writes the configs of a group in the format requested through
+// the format query parameter or, failing that, the Accept header.
+func RenderGroup(ctx context.Context, w http.ResponseWriter, req *http.Request, configs []*Config, meta *Meta) {
+	span := tracer.StartSpanFromContext(ctx, "RenderGroup")
+	defer span.Finish()
+
+	f, status, err := negotiateFormat(req, groupFormats)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := f.encode(&buf, configs); err != nil {
+		tracer.LogError(span, err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrKeyConflict) {
+			// the group exists, just not in this format
+			status = http.StatusNotAcceptable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	writeBody(w, req, f, buf.Bytes(), meta, http.StatusOK)
+}
+
+// WantsNDJSON reports whether the client asked for newline delimited JSON,
+// through ?format=jsonl or the Accept header.
+func WantsNDJSON(req *http.Request) bool {
+	if format := req.URL.Query().Get("format"); format != "" {
+		return format == "jsonl" || format == "ndjson"
+	}
+
+	for _, accepted := range parseAccept(req.Header.Get("Accept")) {
+		if accepted == ndjsonMediaType || accepted == "application/jsonl" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RenderNDJSON streams the elements of the slice items as one JSON document
+// per line.
+func RenderNDJSON(ctx context.Context, w http.ResponseWriter, items interface{}) {
+	span := tracer.StartSpanFromContext(ctx, "RenderNDJSON")
+	defer span.Finish()
+
+	enc := NewNDJSONEncoder(w)
+	v := reflect.ValueOf(items)
+	for i := 0; i < v.Len(); i++ {
+		if err := enc.Encode(v.Index(i).Interface()); err != nil {
+			tracer.LogError(span, err)
+			return
+		}
+	}
+}
+
+// NDJSONEncoder writes one JSON document per line and flushes each, for
+// responses produced while they are read. The headers go out with the first
+// document, so an error before it can still be answered with a status.
+type NDJSONEncoder struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	flusher http.Flusher
+	started bool
+}
+
+func NewNDJSONEncoder(w http.ResponseWriter) *NDJSONEncoder {
+	flusher, _ := w.(http.Flusher)
+	return &NDJSONEncoder{w: w, enc: json.NewEncoder(w), flusher: flusher}
+}
+
+func (e *NDJSONEncoder) Encode(v interface{}) error {
+	e.start()
+
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+
+	return nil
+}
+
+// Started reports whether the response is already under way.
+func (e *NDJSONEncoder) Started() bool {
+	return e.started
+}
+
+// Close sends the headers when nothing was encoded, so an empty stream is
+// still answered as NDJSON.
+func (e *NDJSONEncoder) Close() {
+	e.start()
+}
+
+func (e *NDJSONEncoder) start() {
+	if e.started {
+		return
+	}
+
+	e.w.Header().Set("Content-Type", ndjsonMediaType)
+	setPrivate(e.w.Header())
+	e.started = true
+}
+
+func writeBody(w http.ResponseWriter, req *http.Request, f *groupFormat, body []byte, meta *Meta, status int) {
+	if f.name == "json" && isPretty(req) {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, body, "", "  "); err == nil {
+			body = buf.Bytes()
+		}
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", f.mediaType+"; charset=utf-8")
+	h.Add("Vary", "Accept")
+	setPrivate(h)
+	if meta != nil {
+		etag := strongETag(body)
+		h.Set("ETag", etag)
+		h.Set("X-Consul-Index", strconv.FormatUint(meta.Index, 10))
+		if !meta.Modified.IsZero() {
+			h.Set("Last-Modified", meta.Modified.UTC().Format(http.TimeFormat))
+		}
+		if !meta.Deleted.IsZero() {
+			h.Set("X-Deleted-At", meta.Deleted.UTC().Format(time.RFC3339))
+		}
+
+		if (req.Method == http.MethodGet || req.Method == http.MethodHead) && etagMatches(req.Header.Get("If-None-Match"), etag) {
+			h.Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// setPrivate keeps shared caches from serving a response to another caller.
+// What a response holds depends on the credentials it was requested with,
+// secret values in particular are revealed or redacted by them.
+func setPrivate(h http.Header) {
+	h.Add("Vary", "Authorization, X-API-Key")
+	h.Set("Cache-Control", "private")
+}
+
+// strongETag derives the entity tag from the exact bytes being sent, so every
+// representation of the same stored value (format, pretty printing) gets its
+// own tag while an unchanged value keeps it across reads.
+func strongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using the
+// weak comparison RFC 9110 prescribes for that header.
+func etagMatches(header string, etag string) bool {
+	if header == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isPretty(req *http.Request) bool {
+	return QueryFlag(req, "pretty")
+}
+
+// QueryFlag reports whether a boolean query parameter is set. A bare ?name
+// counts as true.
+func QueryFlag(req *http.Request, name string) bool {
+	values, ok := req.URL.Query()[name]
+	if !ok {
+		return false
+	}
+
+	if len(values) == 0 || values[0] == "" {
+		return true
+	}
+
+	flag, err := strconv.ParseBool(values[0])
+	return err == nil && flag
+}
+
+// negotiateFormat picks one of the offered formats for the response. An
+// explicit format query parameter wins over the Accept header. A format that
+// is not offered, either way, is refused with 406, but only for safe
+// requests; a mutation that already happened is always reported, falling back
+// to the first offered format.
+func negotiateFormat(req *http.Request, offers []groupFormat) (*groupFormat, int, error) {
+	if name := req.URL.Query().Get("format"); name != "" {
+		for i, f := range offers {
+			if f.name == name || containsString(f.aliases, name) {
+				return &offers[i], 0, nil
+			}
+		}
+		return notAcceptable(req, offers, fmt.Errorf("unsupported format %q", name))
+	}
+
+	header := req.Header.Get("Accept")
+	if header == "" {
+		return &offers[0], 0, nil
+	}
+
+	for _, accepted := range parseAccept(header) {
+		for i, f := range offers {
+			if mediaTypeMatches(accepted, f.mediaType) || containsString(f.aliases, accepted) {
+				return &offers[i], 0, nil
+			}
+		}
+	}
+
+	supported := make([]string, 0, len(offers))
+	for _, f := range offers {
+		supported = append(supported, f.mediaType)
+	}
+
+	return notAcceptable(req, offers, fmt.Errorf("not acceptable, supported media types: %s", strings.Join(supported, ", ")))
+}
+
+func notAcceptable(req *http.Request, offers []groupFormat, err error) (*groupFormat, int, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return &offers[0], 0, nil
+	}
+
+	return nil, http.StatusNotAcceptable, err
+}
+
+// parseAccept returns the media ranges of an Accept header ordered by their
+// quality value. Ranges with q=0 are dropped.
+func parseAccept(header string) []string {
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	result := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		result = append(result, r.mediaType)
+	}
+
+	return result
+}
+
+func mediaTypeMatches(accepted string, mediaType string) bool {
+	if accepted == "*/*" || accepted == mediaType {
+		return true
+	}
+
+	if strings.HasSuffix(accepted, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*"))
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ErrKeyConflict is returned for groups whose keys cannot all be nested, such
+// as "db" next to "db.host", or the same key twice under different labels.
+// Dotenv fails the same way for keys that map onto one variable name, such as
+// "db.host" and "db_host". Formats like json or properties hold such groups.
+var ErrKeyConflict = errors.New("config keys conflict in this format")
+
+// nestConfigs turns dotted keys into nested maps, so "db.host" and "db.port"
+// end up under a common "db" table. A key that would replace a value or a
+// table already placed fails with ErrKeyConflict rather than losing a config.
+func nestConfigs(configs []*Config) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+
+	for _, c := range sortedConfigs(configs) {
+		parts := strings.Split(c.Key, ".")
+		node := root
+		for i, part := range parts[:len(parts)-1] {
+			child, ok := node[part]
+			if !ok {
+				child = map[string]interface{}{}
+				node[part] = child
+			}
+
+			childMap, ok := child.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%w: %q and %q", ErrKeyConflict, strings.Join(parts[:i+1], "."), c.Key)
+			}
+			node = childMap
+		}
+
+		last := parts[len(parts)-1]
+		if _, ok := node[last]; ok {
+			return nil, fmt.Errorf("%w: %q is given more than once or also has nested keys", ErrKeyConflict, c.Key)
+		}
+		node[last] = c.Value
+	}
+
+	return root, nil
+}
+
+func encodeGroupJSON(w io.Writer, configs []*Config) error {
+	return json.NewEncoder(w).Encode(configs)
+}
+
+func encodeGroupYAML(w io.Writer, configs []*Config) error {
+	nested, err := nestConfigs(configs)
+	if err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(nested); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+func encodeGroupTOML(w io.Writer, configs []*Config) error {
+	nested, err := nestConfigs(configs)
+	if err != nil {
+		return err
+	}
+
+	return toml.NewEncoder(w).Encode(nested)
+}
+
+func encodeGroupProperties(w io.Writer, configs []*Config) error {
+	keyEscaper := strings.NewReplacer(`\`, `\\`, " ", `\ `, "=", `\=`, ":", `\:`, "#", `\#`, "!", `\!`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	valueEscaper := strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+	for _, c := range sortedConfigs(configs) {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", keyEscaper.Replace(c.Key), valueEscaper.Replace(c.Value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func encodeGroupDotenv(w io.Writer, configs []*Config) error {
+	valueEscaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`)
+
+	sorted := sortedConfigs(configs)
+	keys := make(map[string]string, len(sorted))
+	for _, c := range sorted {
+		name := dotenvKey(c.Key)
+		if other, ok := keys[name]; ok {
+			return fmt.Errorf("%w: %q and %q are both %s", ErrKeyConflict, other, c.Key, name)
+		}
+		keys[name] = c.Key
+	}
+
+	for _, c := range sorted {
+		if _, err := fmt.Fprintf(w, "%s=\"%s\"\n", dotenvKey(c.Key), valueEscaper.Replace(c.Value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dotenvKey maps a config key onto a shell-safe variable name, e.g.
+// "db.host-name" becomes "DB_HOST_NAME".
+func dotenvKey(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, key)
+
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+
+	return name
+}
+
+func sortedConfigs(configs []*Config) []*Config {
+	sorted := make([]*Config, len(configs))
+	copy(sorted, configs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	return sorted
+}
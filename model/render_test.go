@@ -0,0 +1,161 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNestConfigs(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []*Config
+		want    map[string]interface{}
+		wantErr error
+	}{
+		{
+			name:    "dotted keys share a table",
+			configs: []*Config{{Key: "db.host", Value: "h"}, {Key: "db.port", Value: "5432"}, {Key: "name", Value: "n"}},
+			want: map[string]interface{}{
+				"db":   map[string]interface{}{"host": "h", "port": "5432"},
+				"name": "n",
+			},
+		},
+		{
+			name:    "value and nested key",
+			configs: []*Config{{Key: "db.host", Value: "h"}, {Key: "db", Value: "d"}},
+			wantErr: ErrKeyConflict,
+		},
+		{
+			name:    "deeper value and nested key",
+			configs: []*Config{{Key: "a.b", Value: "1"}, {Key: "a.b.c", Value: "2"}},
+			wantErr: ErrKeyConflict,
+		},
+		{
+			name:    "same key twice",
+			configs: []*Config{{Key: "db.host", Value: "h1"}, {Key: "db.host", Value: "h2"}},
+			wantErr: ErrKeyConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nestConfigs(tt.configs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("nestConfigs() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nestConfigs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeGroupDotenv(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []*Config
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "keys become variables",
+			configs: []*Config{{Key: "db.host-name", Value: "h"}, {Key: "1st", Value: `a "b" $c`}},
+			want:    "_1ST=\"a \\\"b\\\" \\$c\"\nDB_HOST_NAME=\"h\"\n",
+		},
+		{
+			name:    "keys mapping onto one variable",
+			configs: []*Config{{Key: "db.host", Value: "h1"}, {Key: "db_host", Value: "h2"}},
+			wantErr: ErrKeyConflict,
+		},
+		{
+			name:    "keys differing in case",
+			configs: []*Config{{Key: "Port", Value: "1"}, {Key: "port", Value: "2"}},
+			wantErr: ErrKeyConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := encodeGroupDotenv(&buf, tt.configs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("encodeGroupDotenv() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && buf.String() != tt.want {
+				t.Errorf("encodeGroupDotenv() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		accept     string
+		want       string
+		wantStatus int
+	}{
+		{name: "default", method: http.MethodGet, target: "/", want: "json"},
+		{name: "format parameter", method: http.MethodGet, target: "/?format=yaml", want: "yaml"},
+		{name: "format alias", method: http.MethodGet, target: "/?format=env", want: "dotenv"},
+		{name: "parameter wins over Accept", method: http.MethodGet, target: "/?format=toml", accept: "application/yaml", want: "toml"},
+		{name: "Accept header", method: http.MethodGet, target: "/", accept: "text/yaml", want: "yaml"},
+		{name: "unsupported format", method: http.MethodGet, target: "/?format=xml", wantStatus: http.StatusNotAcceptable},
+		{name: "unacceptable media type", method: http.MethodGet, target: "/", accept: "application/xml", wantStatus: http.StatusNotAcceptable},
+		{name: "mutation with an unsupported format", method: http.MethodPost, target: "/?format=xml", want: "json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			f, status, err := negotiateFormat(req, groupFormats)
+			if status != tt.wantStatus || (err != nil) != (tt.wantStatus != 0) {
+				t.Fatalf("negotiateFormat() status = %d, error = %v, want %d", status, err, tt.wantStatus)
+			}
+			if err == nil && f.name != tt.want {
+				t.Errorf("negotiateFormat() = %s, want %s", f.name, tt.want)
+			}
+		})
+	}
+}
+
+func TestNDJSONEncoder(t *testing.T) {
+	rec := httptest.NewRecorder()
+	enc := NewNDJSONEncoder(rec)
+	if enc.Started() {
+		t.Fatal("Started() before the first document")
+	}
+
+	for _, v := range []map[string]int{{"a": 1}, {"b": 2}} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enc.Close()
+
+	if got := rec.Header().Get("Content-Type"); got != ndjsonMediaType {
+		t.Errorf("Content-Type = %q, want %q", got, ndjsonMediaType)
+	}
+	if got, want := rec.Body.String(), "{\"a\":1}\n{\"b\":2}\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if !rec.Flushed {
+		t.Error("documents were not flushed")
+	}
+
+	empty := httptest.NewRecorder()
+	NewNDJSONEncoder(empty).Close()
+	if got := empty.Header().Get("Content-Type"); got != ndjsonMediaType {
+		t.Errorf("Content-Type of an empty stream = %q, want %q", got, ndjsonMediaType)
+	}
+}
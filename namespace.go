@@ -0,0 +1,186 @@
+package main
+
+import (
+	poststore "ars-projekat/configstore"
+	"ars-projekat/model"
+	tracer "ars-projekat/tracer"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"mime"
+	"net/http"
+)
+
+// namespaced binds the request to the namespace named in its path, so the
+// store calls of the wrapped handler act on that namespace only. Unknown
+// namespaces are answered with 404.
+func (ts *Service) namespaced(handlerFunc func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		span := tracer.StartSpanFromRequest("namespaced", ts.tracer, req)
+		defer span.Finish()
+
+		name := mux.Vars(req)["ns"]
+		span.SetTag("namespace", name)
+
+		ctx := tracer.ContextWithSpan(req.Context(), span)
+
+		exists, err := ts.store.CheckIfNamespaceExists(ctx, name)
+		if err != nil {
+			tracer.LogError(span, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			err := poststore.ErrNamespaceNotFound
+			tracer.LogError(span, err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		handlerFunc(w, req.WithContext(poststore.WithNamespace(ctx, name)))
+	}
+}
+
+func (ts *Service) createNamespaceHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("createNamespaceHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(tracer.LogString("handler", fmt.Sprintf("handling create namespace at %s\n", req.URL.Path)))
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	namespace, ok := decodeNamespace(ctx, w, req)
+	if !ok {
+		return
+	}
+
+	if !model.ValidNamespace(namespace.Name) {
+		err := errors.New("namespace name must be lowercase letters, digits and dashes, at most 63 characters")
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if namespace.Quota.Configs < 0 || namespace.Quota.Groups < 0 {
+		err := errors.New("quota must not be negative")
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := ts.store.CreateNamespace(ctx, namespace)
+	if errors.Is(err, poststore.ErrNamespaceExists) {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	model.RenderStatus(ctx, w, req, http.StatusCreated, namespace)
+}
+
+func (ts *Service) getNamespacesHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getNamespacesHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(tracer.LogString("handler", fmt.Sprintf("handling get namespaces from %s\n", req.URL.Path)))
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	namespaces, err := ts.store.ListNamespaces(ctx)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	model.RenderJSON(ctx, w, req, namespaces)
+}
+
+func (ts *Service) getNamespaceHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getNamespaceHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(tracer.LogString("handler", fmt.Sprintf("handling get namespace at %s\n", req.URL.Path)))
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	namespace, err := ts.store.GetNamespace(ctx, mux.Vars(req)["ns"])
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), namespaceStatus(err))
+		return
+	}
+
+	model.RenderJSON(ctx, w, req, namespace)
+}
+
+// setNamespaceQuotaHandler replaces the quota of a namespace with the one in
+// the body. Any other field of the body is ignored.
+func (ts *Service) setNamespaceQuotaHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("setNamespaceQuotaHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(tracer.LogString("handler", fmt.Sprintf("handling set namespace quota at %s\n", req.URL.Path)))
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	body, ok := decodeNamespace(ctx, w, req)
+	if !ok {
+		return
+	}
+
+	if body.Quota.Configs < 0 || body.Quota.Groups < 0 {
+		err := errors.New("quota must not be negative")
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	namespace, err := ts.store.UpdateNamespaceQuota(ctx, mux.Vars(req)["ns"], body.Quota)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), namespaceStatus(err))
+		return
+	}
+
+	model.RenderJSON(ctx, w, req, namespace)
+}
+
+func decodeNamespace(ctx context.Context, w http.ResponseWriter, req *http.Request) (*model.NamespaceJSON, bool) {
+	contentType := req.Header.Get("Content-Type")
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if !model.IsSupportedBodyType(mediatype) {
+		err := errors.New("Expect application/json, application/yaml or application/toml Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+
+	namespace, err := model.DecodeNamespace(ctx, req.Body, mediatype)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	return namespace, true
+}
+
+func namespaceStatus(err error) int {
+	if errors.Is(err, poststore.ErrNamespaceNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, poststore.ErrConflict) {
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
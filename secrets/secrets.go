@@ -0,0 +1,270 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keySize = 32
+
+// missReloadInterval is the least time between two reloads of the key file
+// caused by envelopes wrapped under a key that is not known.
+const missReloadInterval = 30 * time.Second
+
+var ErrUnknownKey = errors.New("secret is wrapped by an unknown key")
+
+// Envelope is a value encrypted with its own data key, which in turn is
+// wrapped by a key-encryption key identified by KeyId.
+type Envelope struct {
+	KeyId      string `json:"keyId"`
+	DataKey    []byte `json:"dataKey"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Keyring holds the primary key-encryption key, which wraps every new data
+// key, and any number of previous ones that are still accepted for reading.
+type Keyring struct {
+	mu      sync.RWMutex
+	path    string
+	primary string
+	keks    map[string][]byte
+
+	// lastMiss is when an unknown key id last reloaded the key file, and
+	// unknown remembers ids that were still missing after such a reload.
+	lastMiss time.Time
+	unknown  map[string]time.Time
+}
+
+// LoadKeyring reads the primary key-encryption key from path and previous
+// keys from previousPaths. Every file holds 32 bytes, raw or hex or base64
+// encoded.
+func LoadKeyring(path string, previousPaths ...string) (*Keyring, error) {
+	k := &Keyring{path: path, keks: map[string][]byte{}, unknown: map[string]time.Time{}}
+
+	for _, p := range previousPaths {
+		kek, err := loadKey(p)
+		if err != nil {
+			return nil, err
+		}
+		k.keks[keyId(kek)] = kek
+	}
+
+	if _, err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Reload reads the primary key file again. If it now holds a different key,
+// that key becomes primary and the former one is kept for reading, so the file
+// can be replaced while the service runs. It returns the primary key id.
+// Opening an envelope wrapped by an unknown key reloads the file as well, at
+// most once every missReloadInterval.
+func (k *Keyring) Reload() (string, error) {
+	kek, err := loadKey(k.path)
+	if err != nil {
+		return "", err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.primary = keyId(kek)
+	k.keks[k.primary] = kek
+
+	return k.primary, nil
+}
+
+func (k *Keyring) PrimaryId() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.primary
+}
+
+// Rewrap wraps the data key of env under the primary key-encryption key. The
+// ciphertext is left as it is. It reports false when env already uses the
+// primary key.
+func (k *Keyring) Rewrap(env *Envelope) (*Envelope, bool, error) {
+	k.mu.RLock()
+	primary := k.primary
+	kek := k.keks[primary]
+	k.mu.RUnlock()
+
+	if env.KeyId == primary {
+		return env, false, nil
+	}
+
+	dataKey, err := k.unwrap(env)
+	if err != nil {
+		return nil, false, err
+	}
+
+	wrapped, err := seal(kek, dataKey, []byte(primary))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &Envelope{KeyId: primary, DataKey: wrapped, Ciphertext: env.Ciphertext}, true, nil
+}
+
+// Seal encrypts plaintext under a fresh data key. aad is bound to the
+// ciphertext and has to be passed to Open unchanged.
+func (k *Keyring) Seal(plaintext []byte, aad []byte) (*Envelope, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := seal(dataKey, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	primary := k.primary
+	kek := k.keks[primary]
+	k.mu.RUnlock()
+
+	wrapped, err := seal(kek, dataKey, []byte(primary))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{KeyId: primary, DataKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// Open decrypts env with whichever known key-encryption key wrapped it.
+func (k *Keyring) Open(env *Envelope, aad []byte) ([]byte, error) {
+	dataKey, err := k.unwrap(env)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(dataKey, env.Ciphertext, aad)
+}
+
+func (k *Keyring) unwrap(env *Envelope) ([]byte, error) {
+	k.mu.RLock()
+	kek, ok := k.keks[env.KeyId]
+	k.mu.RUnlock()
+
+	if !ok {
+		k.mu.Lock()
+		kek, ok = k.keks[env.KeyId]
+		reload := !ok && k.mayReload(env.KeyId, time.Now())
+		k.mu.Unlock()
+
+		// Another replica may have rotated to a key this one has not read
+		// yet; once the key file was replaced everywhere, it holds that key.
+		if reload {
+			if _, err := k.Reload(); err == nil {
+				k.mu.Lock()
+				if kek, ok = k.keks[env.KeyId]; !ok {
+					k.unknown[env.KeyId] = time.Now()
+				}
+				k.mu.Unlock()
+			}
+		}
+	}
+
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return open(kek, env.DataKey, []byte(env.KeyId))
+}
+
+// mayReload reports whether a lookup of the unknown key id may reload the key
+// file. It may not while id is cached as missing or when another miss reloaded
+// the file less than missReloadInterval ago. The caller holds k.mu.
+func (k *Keyring) mayReload(id string, now time.Time) bool {
+	for missing, at := range k.unknown {
+		if now.Sub(at) >= missReloadInterval {
+			delete(k.unknown, missing)
+		}
+	}
+	if _, ok := k.unknown[id]; ok || now.Sub(k.lastMiss) < missReloadInterval {
+		return false
+	}
+
+	k.lastMiss = now
+	return true
+}
+
+// seal encrypts with AES-GCM and prepends the random nonce to the result.
+func seal(key []byte, plaintext []byte, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key []byte, sealed []byte, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func loadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == keySize {
+		return data, nil
+	}
+
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == keySize {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%s: expected a %d byte key, raw, hex or base64 encoded", path, keySize)
+}
+
+// keyId names a key without revealing it.
+func keyId(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,250 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeKey(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadKey(t *testing.T) {
+	raw := strings.Repeat("k", keySize)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "raw", content: raw},
+		{name: "hex", content: hex.EncodeToString([]byte(raw)) + "\n"},
+		{name: "base64", content: base64.StdEncoding.EncodeToString([]byte(raw)) + "\n"},
+		{name: "too short", content: "short", wantErr: true},
+		{name: "hex of the wrong size", content: hex.EncodeToString([]byte("short")), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := loadKey(writeKey(t, "kek", tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(key) != raw {
+				t.Errorf("loadKey() = %q, want %q", key, raw)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	keyring, err := LoadKeyring(writeKey(t, "kek", strings.Repeat("a", keySize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := LoadKeyring(writeKey(t, "kek", strings.Repeat("b", keySize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("s3cret")
+	aad := []byte("configs/id/1/password")
+
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		tamper  func(env *Envelope) *Envelope
+		aad     []byte
+		wantErr error
+	}{
+		{name: "same additional data", keyring: keyring, aad: aad},
+		{name: "other additional data", keyring: keyring, aad: []byte("configs/id/2/password"), wantErr: errAny},
+		{name: "no additional data", keyring: keyring, wantErr: errAny},
+		{
+			name:    "changed ciphertext",
+			keyring: keyring,
+			aad:     aad,
+			tamper: func(env *Envelope) *Envelope {
+				ciphertext := bytes.Clone(env.Ciphertext)
+				ciphertext[len(ciphertext)-1] ^= 1
+				return &Envelope{KeyId: env.KeyId, DataKey: env.DataKey, Ciphertext: ciphertext}
+			},
+			wantErr: errAny,
+		},
+		{
+			name:    "data key claimed by another key id",
+			keyring: keyring,
+			aad:     aad,
+			tamper: func(env *Envelope) *Envelope {
+				return &Envelope{KeyId: other.PrimaryId(), DataKey: env.DataKey, Ciphertext: env.Ciphertext}
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{name: "keyring without the key", keyring: other, aad: aad, wantErr: ErrUnknownKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := keyring.Seal(plaintext, aad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(env.Ciphertext, plaintext) {
+				t.Fatal("ciphertext holds the plaintext")
+			}
+			if tt.tamper != nil {
+				env = tt.tamper(env)
+			}
+
+			got, err := tt.keyring.Open(env, tt.aad)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("Open() error = %v", err)
+			case tt.wantErr == errAny && err == nil, tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("Open() error = %v, want %v", err, tt.wantErr)
+			case tt.wantErr == nil && !bytes.Equal(got, plaintext):
+				t.Errorf("Open() = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+// errAny stands for any error in the table above.
+var errAny = errors.New("any error")
+
+func TestReloadRewrap(t *testing.T) {
+	path := writeKey(t, "kek", strings.Repeat("a", keySize))
+
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := keyring.PrimaryId()
+
+	env, err := keyring.Seal([]byte("s3cret"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, changed, err := keyring.Rewrap(env); err != nil || changed {
+		t.Fatalf("Rewrap() under the same key = %v, %v, want unchanged", changed, err)
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Repeat("c", keySize)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	second, err := keyring.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Fatal("Reload() kept the former primary key")
+	}
+
+	// the former key stays readable
+	if _, err := keyring.Open(env, []byte("aad")); err != nil {
+		t.Fatalf("Open() with the former key: %v", err)
+	}
+
+	rewrapped, changed, err := keyring.Rewrap(env)
+	if err != nil || !changed {
+		t.Fatalf("Rewrap() = %v, %v, want changed", changed, err)
+	}
+	if rewrapped.KeyId != second || !bytes.Equal(rewrapped.Ciphertext, env.Ciphertext) {
+		t.Errorf("Rewrap() = %+v, want the same ciphertext under %s", rewrapped, second)
+	}
+
+	fresh, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fresh.Open(rewrapped, []byte("aad"))
+	if err != nil || string(got) != "s3cret" {
+		t.Errorf("Open() with only the new key = %q, %v", got, err)
+	}
+}
+
+func TestOpenPicksUpRotatedKey(t *testing.T) {
+	path := writeKey(t, "kek", strings.Repeat("a", keySize))
+
+	rotating, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Repeat("c", keySize)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	primary, err := rotating.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := rotating.Seal([]byte("s3cret"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := replica.Open(env, []byte("aad")); err != nil || string(got) != "s3cret" {
+		t.Fatalf("Open() on a replica that has not reloaded = %q, %v", got, err)
+	}
+	if replica.PrimaryId() != primary {
+		t.Errorf("replica primary = %s, want %s", replica.PrimaryId(), primary)
+	}
+}
+
+func TestOpenUnknownKeyReloadsOnce(t *testing.T) {
+	other, err := LoadKeyring(writeKey(t, "other", strings.Repeat("d", keySize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := other.Seal([]byte("s3cret"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeKey(t, "kek", strings.Repeat("a", keySize))
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keyring.Open(env, []byte("aad")); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Open() with an unknown key = %v, want %v", err, ErrUnknownKey)
+	}
+	if _, ok := keyring.unknown[env.KeyId]; !ok {
+		t.Fatal("Open() did not remember the unknown key")
+	}
+
+	// the key file now holds the key, but the miss is cached
+	if err := os.WriteFile(path, []byte(strings.Repeat("d", keySize)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.Open(env, []byte("aad")); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Open() within the reload interval = %v, want %v", err, ErrUnknownKey)
+	}
+
+	keyring.lastMiss = keyring.lastMiss.Add(-missReloadInterval)
+	keyring.unknown[env.KeyId] = keyring.unknown[env.KeyId].Add(-missReloadInterval)
+
+	if got, err := keyring.Open(env, []byte("aad")); err != nil || string(got) != "s3cret" {
+		t.Errorf("Open() after the reload interval = %q, %v", got, err)
+	}
+}
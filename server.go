@@ -1,23 +1,305 @@
 package main
 
 import (
+	"ars-projekat/auth"
 	poststore "ars-projekat/configstore"
+	"ars-projekat/logging"
 	"ars-projekat/model"
 	tracer "ars-projekat/tracer"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/opentracing/opentracing-go"
 	"io"
+	"log/slog"
 	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type Service struct {
-	store  *poststore.ConfigStore
-	tracer opentracing.Tracer
-	closer io.Closer
+	store    *poststore.ConfigStore
+	tracer   opentracing.Tracer
+	closer   io.Closer
+	auth     *auth.Authenticator
+	logger   *slog.Logger
+	logLevel *slog.LevelVar
+	// shuttingDown is set once shutdown starts, taking the service out of
+	// rotation before it stops accepting connections.
+	shuttingDown atomic.Bool
+	// maxBodyBytes bounds the bodies peeked at while authorizing.
+	maxBodyBytes int64
+}
+
+// requestID makes sure every request carries an X-Request-ID, reusing a sane
+// one sent by the client, and echoes it in the response.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 || strings.ContainsAny(id, " \t\r\n") {
+			id = model.CreateId()
+			req.Header.Set("X-Request-ID", id)
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		next.ServeHTTP(w, req.WithContext(model.WithRequestID(req.Context(), id)))
+	})
+}
+
+// methodNotAllowed answers a request for a known path with a method none of
+// its routes accept.
+func methodNotAllowed(w http.ResponseWriter, req *http.Request) {
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// logRequests starts the span a request is traced under and writes one log
+// line for it once it is served. Like authenticate, it hands the span on
+// through the request context, so the spans started further down become its
+// children.
+func (ts *Service) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := routeTemplate(req)
+
+		span := tracer.StartSpanFromRequest(req.Method+" "+route, ts.tracer, req)
+		defer span.Finish()
+
+		span.SetTag("http.method", req.Method)
+		span.SetTag("http.route", route)
+
+		ctx := tracer.ContextWithSpan(req.Context(), span)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		next.ServeHTTP(rec, req.WithContext(ctx))
+
+		latency := time.Since(start)
+		span.SetTag("http.status_code", rec.status)
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			span.SetTag("error", true)
+			level = slog.LevelError
+		}
+
+		ts.logger.LogAttrs(ctx, level, "request",
+			slog.String("method", req.Method),
+			slog.String("route", route),
+			slog.String("path", req.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Float64("latency", latency.Seconds()),
+		)
+	})
+}
+
+// authenticate rejects requests without valid credentials and hands the
+// caller's identity to the wrapped handler through the request context. The
+// authenticate span travels in the same context, so spans started by the
+// handler become its children.
+func (ts *Service) authenticate(handlerFunc func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		span := tracer.StartSpanFromRequest("authenticate", ts.tracer, req)
+		defer span.Finish()
+
+		identity, err := ts.auth.Authenticate(req)
+		if err != nil {
+			tracer.LogError(span, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="config_service"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		span.SetTag("auth.subject", identity.Subject)
+		span.SetTag("auth.method", identity.Method)
+
+		ctx := tracer.ContextWithSpan(req.Context(), span)
+
+		handlerFunc(w, req.WithContext(auth.WithIdentity(ctx, identity)))
+	}
+}
+
+// authorize lets the request through only if the authenticated caller holds
+// a role allowing action on the addressed resource, either directly or through
+// a stored policy. Denials are answered with 403 and recorded on the span.
+func (ts *Service) authorize(action string, kind string, handlerFunc func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		span := tracer.StartSpanFromRequest("authorize", ts.tracer, req)
+		defer span.Finish()
+
+		identity, ok := auth.IdentityFromContext(req.Context())
+		if !ok {
+			err := errors.New("unauthenticated")
+			tracer.LogError(span, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		resource := auth.Resource{Kind: kind}
+		if kind != auth.ResourceAdmin {
+			resource.Id = mux.Vars(req)["uuid"]
+			resource.Namespace = mux.Vars(req)["ns"]
+		}
+
+		span.SetTag("auth.subject", identity.Subject)
+		span.SetTag("auth.action", action)
+		span.SetTag("auth.resource", kind)
+
+		ctx := tracer.ContextWithSpan(req.Context(), span)
+
+		var policies []*model.PolicyJSON
+		allowed, err := ts.authorized(ctx, req, identity, action, &resource, &policies)
+		if err != nil {
+			tracer.LogError(span, err)
+			http.Error(w, err.Error(), authorizeStatus(err))
+			return
+		}
+
+		if !allowed {
+			err := fmt.Errorf("%s may not %s %s", identity.Subject, action, req.URL.Path)
+			span.SetTag("auth.decision", "deny")
+			tracer.LogError(span, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		span.SetTag("auth.decision", "allow")
+		req = req.WithContext(ctx)
+
+		if action == auth.ActionRead {
+			reveal, err := ts.authorized(ctx, req, identity, auth.ActionReveal, &resource, &policies)
+			if err != nil {
+				tracer.LogError(span, err)
+				http.Error(w, err.Error(), authorizeStatus(err))
+				return
+			}
+			if reveal {
+				span.SetTag("auth.reveal", true)
+				req = req.WithContext(auth.WithReveal(req.Context()))
+			}
+		}
+
+		handlerFunc(w, req)
+	}
+}
+
+func authorizeStatus(err error) int {
+	if bodyTooLarge(err) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// authorized checks the caller's own roles first and only loads the stored
+// policies, once per request, when those are not enough. The label sets of a
+// group are only looked up when one of the caller's policies has a selector.
+func (ts *Service) authorized(ctx context.Context, req *http.Request, identity *auth.Identity, action string, resource *auth.Resource, policies *[]*model.PolicyJSON) (bool, error) {
+	if auth.Authorize(identity, nil, action, *resource) {
+		return true, nil
+	}
+
+	if *policies == nil {
+		loaded, err := ts.store.ListPolicies(ctx)
+		if err != nil {
+			return false, err
+		}
+		*policies = loaded
+	}
+
+	if resource.Kind == auth.ResourceGroup && resource.LabelSets == nil && auth.LabelScoped(identity, *policies) {
+		sets, err := ts.groupLabelSets(ctx, req)
+		if err != nil {
+			return false, err
+		}
+		resource.LabelSets = sets
+	}
+
+	return auth.Authorize(identity, *policies, action, *resource), nil
+}
+
+// groupLabelSets returns the label sets of the group configs a request reads
+// or changes. A read filtered by labels only returns configs with exactly
+// that label set, and a new version or config only has the labels in its
+// body. Everything else acts on what is stored under the group or version.
+func (ts *Service) groupLabelSets(ctx context.Context, req *http.Request) ([][]model.LabelJSON, error) {
+	vars := mux.Vars(req)
+	ctx = poststore.WithNamespace(ctx, vars["ns"])
+	route := routeTemplate(req)
+
+	var labels []string
+	switch {
+	case req.Method == http.MethodGet && model.DecodeQueryLabels(req.URL.Query()) != "":
+		labels = []string{model.DecodeQueryLabels(req.URL.Query())}
+	case req.Method == http.MethodPost && strings.HasSuffix(route, "/groups/{uuid}/{ver}/configs/"):
+		groupConfig := &model.GroupConfigJSON{}
+		err := peekBody(req, ts.maxBodyBytes, func(r io.Reader, mediatype string) (err error) {
+			groupConfig, err = model.DecodeGroupConfig(ctx, r, mediatype)
+			return err
+		})
+		if bodyTooLarge(err) {
+			return nil, err
+		}
+		if err == nil {
+			labels = []string{model.DecodeJSONLabels(ctx, groupConfig.Labels)}
+		}
+	case req.Method == http.MethodPost && strings.HasSuffix(route, "/groups/{uuid}/"):
+		group := &model.GroupJSON{}
+		err := peekBody(req, ts.maxBodyBytes, func(r io.Reader, mediatype string) (err error) {
+			group, err = model.DecodeGroup(ctx, r, mediatype)
+			return err
+		})
+		if bodyTooLarge(err) {
+			return nil, err
+		}
+		if err == nil {
+			for _, c := range group.Configs {
+				labels = append(labels, model.DecodeJSONLabels(ctx, c.Labels))
+			}
+		}
+	default:
+		stored, err := ts.store.GroupLabelSets(ctx, vars["uuid"], vars["ver"])
+		if err != nil {
+			return nil, err
+		}
+		labels = stored
+	}
+
+	// an empty result is not nil, so it is not looked up a second time
+	sets := [][]model.LabelJSON{}
+	for _, l := range labels {
+		sets = append(sets, model.ParseLabels(l))
+	}
+
+	return sets, nil
+}
+
+// peekBody decodes the request body with decode and puts it back for the
+// handler. A body that cannot be decoded is left for the handler to reject,
+// and one longer than limit fails with *http.MaxBytesError.
+func peekBody(req *http.Request, limit int64, decode func(r io.Reader, mediatype string) error) error {
+	mediatype, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(nil, req.Body, limit))
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	return decode(bytes.NewReader(body), mediatype)
+}
+
+func bodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
 }
 
 func (ts *Service) IdempotencyCheck(handlerFunc func(context.Context, http.ResponseWriter, *http.Request) string) func(http.ResponseWriter, *http.Request) {
@@ -25,7 +307,7 @@ func (ts *Service) IdempotencyCheck(handlerFunc func(context.Context, http.Respo
 		span := tracer.StartSpanFromRequest("IdempotencyCheck", ts.tracer, req)
 		defer span.Finish()
 
-		ctx := tracer.ContextWithSpan(context.Background(), span)
+		ctx := tracer.ContextWithSpan(req.Context(), span)
 
 		idempotencyKey := req.Header.Get("Idempotency-Key")
 		if idempotencyKey == "" {
@@ -41,9 +323,11 @@ func (ts *Service) IdempotencyCheck(handlerFunc func(context.Context, http.Respo
 		}
 
 		if keyExists {
-			model.RenderJSON(ctx, w, storedKey)
+			idempotencyHits.Inc()
+			model.RenderJSON(ctx, w, req, storedKey)
 			return
 		}
+		idempotencyMisses.Inc()
 
 		id := handlerFunc(ctx, w, req)
 		if id != "" {
@@ -65,13 +349,13 @@ func (ts *Service) createConfigHandler(ctx context.Context, w http.ResponseWrite
 		return ""
 	}
 
-	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
+	if !model.IsSupportedBodyType(mediatype) {
+		err := errors.New("Expect application/json, application/yaml or application/toml Content-Type")
 		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
 		return ""
 	}
 
-	rt, err := model.DecodeConfig(ctx, req.Body)
+	rt, err := model.DecodeConfig(ctx, req.Body, mediatype)
 	if err != nil {
 		tracer.LogError(span, err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -80,12 +364,12 @@ func (ts *Service) createConfigHandler(ctx context.Context, w http.ResponseWrite
 
 	id, err := ts.store.CreateConfig(ctx, rt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), createStatus(err))
 		tracer.LogError(span, err)
 		return ""
 	}
 
-	model.RenderJSON(ctx, w, id)
+	model.RenderJSON(ctx, w, req, id)
 
 	return id
 
@@ -107,13 +391,13 @@ func (ts *Service) createConfigVersionHandler(ctx context.Context, w http.Respon
 		return ""
 	}
 
-	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
+	if !model.IsSupportedBodyType(mediatype) {
+		err := errors.New("Expect application/json, application/yaml or application/toml Content-Type")
 		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
 		return ""
 	}
 
-	rt, err := model.DecodeConfig(ctx, req.Body)
+	rt, err := model.DecodeConfig(ctx, req.Body, mediatype)
 	if err != nil {
 		tracer.LogError(span, err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -127,7 +411,7 @@ func (ts *Service) createConfigVersionHandler(ctx context.Context, w http.Respon
 		return ""
 	}
 
-	model.RenderJSON(ctx, w, id)
+	model.RenderJSON(ctx, w, req, id)
 
 	return id
 }
@@ -145,13 +429,13 @@ func (ts *Service) createGroupHandler(ctx context.Context, w http.ResponseWriter
 		return ""
 	}
 
-	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
+	if !model.IsSupportedBodyType(mediatype) {
+		err := errors.New("Expect application/json, application/yaml or application/toml Content-Type")
 		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
 		return ""
 	}
 
-	rt, err := model.DecodeGroup(ctx, req.Body)
+	rt, err := model.DecodeGroup(ctx, req.Body, mediatype)
 	if err != nil {
 		tracer.LogError(span, err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -161,11 +445,11 @@ func (ts *Service) createGroupHandler(ctx context.Context, w http.ResponseWriter
 	id, err := ts.store.CreateGroup(ctx, rt)
 	if err != nil {
 		tracer.LogError(span, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), createStatus(err))
 		return ""
 	}
 
-	model.RenderJSON(ctx, w, id)
+	model.RenderJSON(ctx, w, req, id)
 
 	return id
 }
@@ -179,9 +463,12 @@ func (ts *Service) getConfigHandler(w http.ResponseWriter, req *http.Request) {
 	id := mux.Vars(req)["uuid"]
 	ver := mux.Vars(req)["ver"]
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(req.Context(), span)
 
-	config, err := ts.store.GetConfig(ctx, id, ver)
+	config, meta, err := ts.store.GetConfig(ctx, id, ver)
+	if errors.Is(err, poststore.ErrConfigNotFound) && model.QueryFlag(req, "includeDeleted") {
+		config, meta, err = ts.store.GetDeletedConfig(ctx, id, ver)
+	}
 	if err != nil {
 		err := errors.New("key not found")
 		tracer.LogError(span, err)
@@ -189,7 +476,7 @@ func (ts *Service) getConfigHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	model.RenderJSON(ctx, w, config)
+	model.Render(ctx, w, req, config, meta)
 }
 
 func (ts *Service) getGroupHandler(w http.ResponseWriter, req *http.Request) {
@@ -201,16 +488,19 @@ func (ts *Service) getGroupHandler(w http.ResponseWriter, req *http.Request) {
 	id := mux.Vars(req)["uuid"]
 	ver := mux.Vars(req)["ver"]
 	labels := model.DecodeQueryLabels(req.URL.Query())
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(req.Context(), span)
 
-	group, err := ts.store.GetGroup(ctx, id, ver, labels)
+	group, meta, err := ts.store.GetGroup(ctx, id, ver, labels)
+	if errors.Is(err, poststore.ErrGroupNotFound) && model.QueryFlag(req, "includeDeleted") {
+		group, meta, err = ts.store.GetDeletedGroup(ctx, id, ver, labels)
+	}
 	if err != nil {
 		tracer.LogError(span, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	model.RenderJSON(ctx, w, group)
+	model.RenderGroup(ctx, w, req, group, meta)
 }
 
 func (ts *Service) delConfigHandler(w http.ResponseWriter, req *http.Request) {
@@ -224,15 +514,15 @@ func (ts *Service) delConfigHandler(w http.ResponseWriter, req *http.Request) {
 	id := mux.Vars(req)["uuid"]
 	ver := mux.Vars(req)["ver"]
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(req.Context(), span)
 	r, err := ts.store.DeleteConfig(ctx, id, ver)
 	if err != nil {
-		err := errors.New("key not found")
 		tracer.LogError(span, err)
-		http.Error(w, err.Error(), http.StatusNotFound)
+		http.Error(w, err.Error(), deleteStatus(err))
+		return
 	}
 
-	model.RenderJSON(ctx, w, r)
+	model.RenderJSON(ctx, w, req, r)
 }
 
 func (ts *Service) addConfigToGroupHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) string {
@@ -254,13 +544,13 @@ func (ts *Service) addConfigToGroupHandler(ctx context.Context, w http.ResponseW
 		return ""
 	}
 
-	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
+	if !model.IsSupportedBodyType(mediatype) {
+		err := errors.New("Expect application/json, application/yaml or application/toml Content-Type")
 		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
 		return ""
 	}
 
-	groupConfig, err := model.DecodeGroupConfig(ctx, req.Body)
+	groupConfig, err := model.DecodeGroupConfig(ctx, req.Body, mediatype)
 	if err != nil {
 		tracer.LogError(span, err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -269,13 +559,19 @@ func (ts *Service) addConfigToGroupHandler(ctx context.Context, w http.ResponseW
 
 	_, err = ts.store.AddConfigToGroup(ctx, id, ver, groupConfig)
 
-	if err != nil {
+	if errors.Is(err, poststore.ErrGroupNotFound) {
 		err := errors.New("key not found")
 		tracer.LogError(span, err)
 		http.Error(w, err.Error(), http.StatusNotFound)
+		return ""
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return ""
 	}
 
-	model.RenderJSON(ctx, w, id)
+	model.RenderJSON(ctx, w, req, id)
 
 	return id
 }
@@ -297,13 +593,13 @@ func (ts *Service) createGroupVersionHandler(ctx context.Context, w http.Respons
 		return ""
 	}
 
-	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
+	if !model.IsSupportedBodyType(mediatype) {
+		err := errors.New("Expect application/json, application/yaml or application/toml Content-Type")
 		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
 		return ""
 	}
 
-	rt, err := model.DecodeGroup(ctx, req.Body)
+	rt, err := model.DecodeGroup(ctx, req.Body, mediatype)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return ""
@@ -315,7 +611,7 @@ func (ts *Service) createGroupVersionHandler(ctx context.Context, w http.Respons
 		return ""
 	}
 
-	model.RenderJSON(ctx, w, id)
+	model.RenderJSON(ctx, w, req, id)
 
 	return id
 }
@@ -331,14 +627,559 @@ func (ts *Service) delGroupHandler(w http.ResponseWriter, req *http.Request) {
 	id := mux.Vars(req)["uuid"]
 	ver := mux.Vars(req)["ver"]
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(req.Context(), span)
 
 	r, err := ts.store.DeleteGroup(ctx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), deleteStatus(err))
+		return
+	}
+
+	model.RenderJSON(ctx, w, req, r)
+}
+
+func (ts *Service) exportHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("exportHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling export at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	enc := model.NewNDJSONEncoder(w)
+	err := ts.store.Export(ctx, func(record *model.RecordJSON) error {
+		return enc.Encode(record)
+	})
+	if err != nil {
+		tracer.LogError(span, err)
+		if enc.Started() {
+			// the status went out with the first record; cut the stream
+			// short so the client does not take it for a whole export
+			ts.logger.ErrorContext(ctx, "export aborted", slog.Any("error", err))
+			panic(http.ErrAbortHandler)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	enc.Close()
+}
+
+func (ts *Service) importHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("importHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling import at %s\n", req.URL.Path)),
+	)
+
+	contentType := req.Header.Get("Content-Type")
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if mediatype != "application/x-ndjson" {
+		err := errors.New("Expect application/x-ndjson Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	records, err := model.DecodeRecords(ctx, req.Body)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	imported := 0
+	for _, r := range records {
+		written, err := ts.store.Import(ctx, r)
+		if errors.Is(err, poststore.ErrNamespaceNotFound) {
+			tracer.LogError(span, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			tracer.LogError(span, err)
+			http.Error(w, err.Error(), createStatus(err))
+			return
+		}
+		if written {
+			imported++
+		}
+	}
+
+	model.RenderJSON(ctx, w, req, map[string]int{"Imported": imported, "Skipped": len(records) - imported})
+}
+
+func (ts *Service) createPolicyHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) string {
+	span := tracer.StartSpanFromContext(ctx, "createPolicyHandler")
+	defer span.Finish()
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling create policy at %s\n", req.URL.Path)),
+	)
+	contentType := req.Header.Get("Content-Type")
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return ""
+	}
+
+	if !model.IsSupportedBodyType(mediatype) {
+		err := errors.New("Expect application/json, application/yaml or application/toml Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return ""
+	}
+
+	policy, err := model.DecodePolicy(ctx, req.Body, mediatype)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return ""
+	}
+
+	if policy.Subject == "" || !auth.IsRole(policy.Role) {
+		err := errors.New("policy needs a subject and one of the roles reader, revealer, editor or admin")
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return ""
+	}
+
+	if len(policy.Labels) > 0 && len(policy.Configs) > 0 {
+		err := errors.New("label selectors only apply to groups, configs have no labels")
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return ""
+	}
+
+	if policy.Namespace != "" && !model.ValidNamespace(policy.Namespace) {
+		err := fmt.Errorf("invalid namespace %q", policy.Namespace)
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return ""
+	}
+
+	id, err := ts.store.CreatePolicy(ctx, policy)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return ""
+	}
+
+	model.RenderJSON(ctx, w, req, id)
+
+	return id
+}
+
+func (ts *Service) getPoliciesHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getPoliciesHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(tracer.LogString("handler", fmt.Sprintf("handling get policies from %s\n", req.URL.Path)))
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	policies, err := ts.store.ListPolicies(ctx)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	model.RenderJSON(ctx, w, req, policies)
+}
+
+func (ts *Service) delPolicyHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("delPolicyHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling delete policy at %s\n", req.URL.Path)),
+	)
+
+	id := mux.Vars(req)["uuid"]
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	r, err := ts.store.DeletePolicy(ctx, id)
 	if err != nil {
 		err := errors.New("key not found")
 		tracer.LogError(span, err)
 		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	model.RenderJSON(ctx, w, req, r)
+}
+
+const (
+	auditPageSize    = 100
+	maxAuditPageSize = 1000
+)
+
+func (ts *Service) getAuditHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getAuditHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(tracer.LogString("handler", fmt.Sprintf("handling get audit from %s\n", req.URL.Path)))
+
+	query := req.URL.Query()
+	filter := &model.AuditFilter{
+		Actor:    query.Get("actor"),
+		Action:   query.Get("action"),
+		Resource: query.Get("resource"),
+		Limit:    auditPageSize,
+		After:    query.Get("after"),
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			err := fmt.Errorf("limit: expected a number from 1 to %d", maxAuditPageSize)
+			tracer.LogError(span, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := query.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				err := fmt.Errorf("%s: expected RFC 3339 time: %w", name, err)
+				tracer.LogError(span, err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	entries, next, err := ts.store.ListAudit(ctx, filter)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the next page repeats the query, continuing after the last entry
+	if next != "" {
+		query.Set("after", next)
+		nextURL := url.URL{Path: req.URL.Path, RawQuery: query.Encode()}
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL.String()))
+	}
+
+	if model.WantsNDJSON(req) {
+		model.RenderNDJSON(ctx, w, entries)
+		return
+	}
+
+	model.RenderJSON(ctx, w, req, entries)
+}
+
+func (ts *Service) rotateKeysHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("rotateKeysHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling key rotation at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	status, err := ts.store.StartKeyRotation(ctx)
+	if errors.Is(err, poststore.ErrRotationRunning) {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	model.RenderStatus(ctx, w, req, http.StatusAccepted, status)
+}
+
+func (ts *Service) getKeyRotationHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getKeyRotationHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(tracer.LogString("handler", fmt.Sprintf("handling get key rotation from %s\n", req.URL.Path)))
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	model.RenderJSON(ctx, w, req, ts.store.RotationStatus())
+}
+
+func (ts *Service) restoreConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("restoreConfigHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling restore config at %s\n", req.URL.Path)),
+	)
+
+	id := mux.Vars(req)["uuid"]
+	ver := mux.Vars(req)["ver"]
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	r, err := ts.store.RestoreConfig(ctx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), restoreStatus(err))
+		return
+	}
+
+	model.RenderJSON(ctx, w, req, r)
+}
+
+func (ts *Service) restoreGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("restoreGroupHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling restore group at %s\n", req.URL.Path)),
+	)
+
+	id := mux.Vars(req)["uuid"]
+	ver := mux.Vars(req)["ver"]
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	r, err := ts.store.RestoreGroup(ctx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), restoreStatus(err))
+		return
+	}
+
+	model.RenderJSON(ctx, w, req, r)
+}
+
+func restoreStatus(err error) int {
+	switch {
+	case errors.Is(err, poststore.ErrConfigNotFound), errors.Is(err, poststore.ErrGroupNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, poststore.ErrVersionExists), errors.Is(err, poststore.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, poststore.ErrQuotaExceeded):
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+func (ts *Service) delConfigVersionsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("delConfigVersionsHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling delete all config versions at %s\n", req.URL.Path)),
+	)
+
+	id := mux.Vars(req)["uuid"]
+
+	if err := confirmDelete(req, id); err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	r, err := ts.store.DeleteConfigVersions(ctx, id)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), deleteStatus(err))
+		return
+	}
+
+	model.RenderJSON(ctx, w, req, r)
+}
+
+func (ts *Service) delGroupVersionsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("delGroupVersionsHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("handling delete all group versions at %s\n", req.URL.Path)),
+	)
+
+	id := mux.Vars(req)["uuid"]
+
+	if err := confirmDelete(req, id); err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	r, err := ts.store.DeleteGroupVersions(ctx, id)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), deleteStatus(err))
+		return
+	}
+
+	model.RenderJSON(ctx, w, req, r)
+}
+
+// confirmDelete guards deletes of every version of a resource, which have to
+// repeat the id in the X-Confirm-Delete header.
+func confirmDelete(req *http.Request, id string) error {
+	if req.Header.Get("X-Confirm-Delete") != id {
+		return fmt.Errorf("deleting every version requires the header X-Confirm-Delete: %s", id)
+	}
+
+	return nil
+}
+
+// createStatus answers a create over quota with 403, like a request the
+// caller is not allowed to make.
+func createStatus(err error) int {
+	if errors.Is(err, poststore.ErrQuotaExceeded) {
+		return http.StatusForbidden
+	}
+	if errors.Is(err, poststore.ErrConflict) {
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+func deleteStatus(err error) int {
+	if errors.Is(err, poststore.ErrConfigNotFound) || errors.Is(err, poststore.ErrGroupNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, poststore.ErrConflict) {
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+func (ts *Service) getLogLevelHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getLogLevelHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(tracer.LogString("handler", fmt.Sprintf("handling get log level at %s\n", req.URL.Path)))
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	model.RenderJSON(ctx, w, req, model.LogLevelJSON{Level: ts.logLevel.Level().String()})
+}
+
+func (ts *Service) setLogLevelHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("setLogLevelHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(tracer.LogString("handler", fmt.Sprintf("handling set log level at %s\n", req.URL.Path)))
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	contentType := req.Header.Get("Content-Type")
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !model.IsSupportedBodyType(mediatype) {
+		err := errors.New("Expect application/json, application/yaml or application/toml Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	level, err := model.DecodeLogLevel(ctx, req.Body, mediatype)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	previous := ts.logLevel.Level()
+	if err := logging.SetLevel(ts.logLevel, level.Level); err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ts.logger.InfoContext(ctx, "log level changed",
+		slog.String("from", previous.String()),
+		slog.String("to", ts.logLevel.Level().String()),
+	)
+
+	model.RenderJSON(ctx, w, req, model.LogLevelJSON{Level: ts.logLevel.Level().String()})
+}
+
+// healthzHandler reports that the process is alive. It checks no
+// dependencies, so a Consul outage does not get the service restarted.
+func (ts *Service) healthzHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("healthzHandler", ts.tracer, req)
+	defer span.Finish()
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	model.RenderJSON(ctx, w, req, model.HealthJSON{Status: model.HealthOK})
+}
+
+// readyzHandler reports whether the service can serve requests: Consul has
+// to have a leader and shutdown must not have started. Tracing is reported
+// but never makes the service unready.
+func (ts *Service) readyzHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("readyzHandler", ts.tracer, req)
+	defer span.Finish()
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	if ts.shuttingDown.Load() {
+		model.RenderStatus(ctx, w, req, http.StatusServiceUnavailable, model.HealthJSON{Status: model.HealthShuttingDown})
+		return
+	}
+
+	health := model.HealthJSON{
+		Status: model.HealthOK,
+		Checks: map[string]model.HealthCheckJSON{},
+	}
+
+	consulCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	_, err := ts.store.Leader(consulCtx)
+	consul := model.HealthCheckJSON{Status: model.HealthOK, Latency: time.Since(start).Seconds()}
+	if err != nil {
+		tracer.LogError(span, err)
+		consul.Status = model.HealthFailing
+		consul.Error = err.Error()
+		health.Status = model.HealthFailing
+	}
+	health.Checks["consul"] = consul
+
+	tracing := model.HealthCheckJSON{Status: model.HealthOK}
+	if !tracer.Enabled(ts.tracer) {
+		tracing.Status = model.HealthDisabled
+	}
+	health.Checks["tracing"] = tracing
+
+	status := http.StatusOK
+	if health.Status != model.HealthOK {
+		status = http.StatusServiceUnavailable
 	}
 
-	model.RenderJSON(ctx, w, r)
+	model.RenderStatus(ctx, w, req, status, health)
 }
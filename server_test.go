@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGroupLabelSetsBodyLimit(t *testing.T) {
+	body := `{"configs":[{"key":"db.host","value":"h","labels":[{"key":"env","value":"prod"}]}]}`
+
+	tests := []struct {
+		name       string
+		limit      int64
+		wantStatus int
+	}{
+		{name: "within the limit", limit: int64(len(body)), wantStatus: http.StatusOK},
+		{name: "over the limit", limit: int64(len(body)) - 1, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := &Service{maxBodyBytes: tt.limit}
+
+			router := mux.NewRouter()
+			router.HandleFunc("/groups/{uuid}/", func(w http.ResponseWriter, req *http.Request) {
+				sets, err := ts.groupLabelSets(req.Context(), req)
+				if err != nil {
+					http.Error(w, err.Error(), authorizeStatus(err))
+					return
+				}
+
+				// the handler still gets the whole body
+				rest, err := io.ReadAll(req.Body)
+				if err != nil || string(rest) != body {
+					http.Error(w, fmt.Sprintf("body left for the handler = %q, %v", rest, err), http.StatusInternalServerError)
+					return
+				}
+				fmt.Fprint(w, len(sets))
+			}).Methods(http.MethodPost)
+
+			req := httptest.NewRequest(http.MethodPost, "/groups/g1/", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusOK && rec.Body.String() != "1" {
+				t.Errorf("label sets = %s, want 1", rec.Body)
+			}
+		})
+	}
+}
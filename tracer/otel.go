@@ -0,0 +1,87 @@
+package trcer
+
+import (
+	"ars-projekat/config"
+	"context"
+	"fmt"
+	opentracing "github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"io"
+	"os"
+	"time"
+)
+
+// initOTel sets up an OpenTelemetry tracer provider exporting over OTLP and
+// bridges it to opentracing, so spans started through this package end up
+// in OpenTelemetry. The exporter reads its endpoint, headers and TLS settings
+// from the standard OTEL_EXPORTER_OTLP_* variables.
+func initOTel(service string, sampling config.SamplerConfig) (opentracing.Tracer, io.Closer, error) {
+	ctx := context.Background()
+
+	exporter, err := newOTLPExporter(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", service)),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES win over the defaults.
+	res, err = resource.Merge(res, resource.Environment())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(otelSampler(sampling)),
+	)
+
+	propagator := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	bridge, _ := otelbridge.NewTracerPair(provider.Tracer(service))
+	bridge.SetTextMapPropagator(propagator)
+
+	return bridge, closerFunc(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return provider.Shutdown(ctx)
+	}), nil
+}
+
+func newOTLPExporter(ctx context.Context) (*otlptrace.Exporter, error) {
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+
+	switch protocol {
+	case "", "grpc":
+		return otlptracegrpc.New(ctx)
+	case "http/protobuf":
+		return otlptracehttp.New(ctx)
+	}
+
+	return nil, fmt.Errorf("unsupported OTLP protocol %q", protocol)
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
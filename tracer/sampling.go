@@ -0,0 +1,139 @@
+package trcer
+
+import (
+	"ars-projekat/config"
+	"fmt"
+	"github.com/gorilla/mux"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// routeRates holds the per-route overrides of the tracer set up by Init.
+var routeRates map[string]float64
+
+// routeSampling decides whether a request on a route with an override is
+// traced. The decision is a sampling.priority tag, which both the Jaeger
+// tracer and the OpenTelemetry sampler honour.
+func routeSampling(r *http.Request) (opentracing.Tag, bool) {
+	if len(routeRates) == 0 {
+		return opentracing.Tag{}, false
+	}
+
+	route := r.URL.Path
+	if current := mux.CurrentRoute(r); current != nil {
+		if tpl, err := current.GetPathTemplate(); err == nil {
+			route = tpl
+		}
+	}
+
+	rate, ok := routeRates[route]
+	if !ok {
+		return opentracing.Tag{}, false
+	}
+
+	priority := uint16(0)
+	if rate > 0 && rand.Float64() < rate {
+		priority = 1
+	}
+
+	return opentracing.Tag{Key: string(ext.SamplingPriority), Value: priority}, true
+}
+
+// otelSampler builds the OpenTelemetry sampler for cfg.
+func otelSampler(cfg config.SamplerConfig) sdktrace.Sampler {
+	var sampler sdktrace.Sampler
+	switch cfg.Type {
+	case config.SamplerProbabilistic:
+		sampler = sdktrace.TraceIDRatioBased(cfg.Param)
+	case config.SamplerRateLimiting:
+		sampler = newRateLimitingSampler(cfg.Param)
+	default:
+		if cfg.Param == 0 {
+			sampler = sdktrace.NeverSample()
+		} else {
+			sampler = sdktrace.AlwaysSample()
+		}
+	}
+
+	if cfg.ParentBased {
+		sampler = sdktrace.ParentBased(sampler)
+	}
+
+	return prioritySampler{next: sampler}
+}
+
+// prioritySampler lets a sampling.priority attribute, set for routes with an
+// override, decide over the configured sampler.
+type prioritySampler struct {
+	next sdktrace.Sampler
+}
+
+func (s prioritySampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	for _, attr := range p.Attributes {
+		if string(attr.Key) != string(ext.SamplingPriority) {
+			continue
+		}
+
+		decision := sdktrace.Drop
+		if attr.Value.AsInt64() > 0 {
+			decision = sdktrace.RecordAndSample
+		}
+		return sdktrace.SamplingResult{
+			Decision:   decision,
+			Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+
+	return s.next.ShouldSample(p)
+}
+
+func (s prioritySampler) Description() string {
+	return "Priority{" + s.next.Description() + "}"
+}
+
+// rateLimitingSampler samples at most a fixed number of traces per second,
+// using a token bucket that holds up to one second worth of traces, and at
+// least one trace, so rates below one per second still sample.
+type rateLimitingSampler struct {
+	mu       sync.Mutex
+	perSec   float64
+	tokens   float64
+	lastTime time.Time
+}
+
+func newRateLimitingSampler(perSec float64) *rateLimitingSampler {
+	return &rateLimitingSampler{perSec: perSec, tokens: perSec, lastTime: time.Now()}
+}
+
+func (s *rateLimitingSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	s.mu.Lock()
+	now := time.Now()
+	s.tokens += now.Sub(s.lastTime).Seconds() * s.perSec
+	if capacity := math.Max(s.perSec, 1); s.tokens > capacity {
+		s.tokens = capacity
+	}
+	s.lastTime = now
+
+	decision := sdktrace.Drop
+	if s.tokens >= 1 {
+		s.tokens--
+		decision = sdktrace.RecordAndSample
+	}
+	s.mu.Unlock()
+
+	return sdktrace.SamplingResult{
+		Decision:   decision,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+func (s *rateLimitingSampler) Description() string {
+	return fmt.Sprintf("RateLimitingSampler{%g}", s.perSec)
+}
@@ -0,0 +1,120 @@
+package trcer
+
+import (
+	"ars-projekat/config"
+	"github.com/gorilla/mux"
+	opentracing "github.com/opentracing/opentracing-go"
+	otelbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRouteSampling(t *testing.T) {
+	routeRates = map[string]float64{"/healthz": 0, "/configs/{uuid}/": 1}
+	t.Cleanup(func() { routeRates = nil })
+
+	tests := []struct {
+		name        string
+		param       float64
+		path        string
+		traceparent string
+		want        int
+	}{
+		{name: "route kept although the sampler drops", param: 0, path: "/configs/42/", want: 2},
+		{name: "route dropped although the sampler keeps", param: 1, path: "/healthz", want: 0},
+		{name: "route without an override", param: 1, path: "/other", want: 2},
+		{
+			name:        "sampled parent wins over the override",
+			param:       0,
+			path:        "/healthz",
+			traceparent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			want:        2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider(
+				sdktrace.WithSampler(otelSampler(config.SamplerConfig{Type: config.SamplerConst, Param: tt.param, ParentBased: true})),
+				sdktrace.WithSpanProcessor(recorder),
+			)
+			bridge, _ := otelbridge.NewTracerPair(provider.Tracer("test"))
+			bridge.SetTextMapPropagator(propagation.TraceContext{})
+
+			// the root span is started by router middleware, as in the
+			// service, with a child started from the request context
+			router := mux.NewRouter()
+			router.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					span := StartSpanFromRequest("request", bridge, req)
+					defer span.Finish()
+					next.ServeHTTP(w, req.WithContext(opentracing.ContextWithSpan(req.Context(), span)))
+				})
+			})
+			handler := func(w http.ResponseWriter, req *http.Request) {
+				StartSpanFromRequest("handler", bridge, req).Finish()
+			}
+			router.HandleFunc("/configs/{uuid}/", handler)
+			router.HandleFunc("/healthz", handler)
+			router.HandleFunc("/other", handler)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got := len(recorder.Ended()); got != tt.want {
+				t.Errorf("recorded %d spans, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitingSampler(t *testing.T) {
+	tests := []struct {
+		name    string
+		perSec  float64
+		elapsed time.Duration
+		want    []sdktrace.SamplingDecision
+	}{
+		{
+			name:    "rate below one per second",
+			perSec:  0.5,
+			elapsed: 2 * time.Second,
+			want:    []sdktrace.SamplingDecision{sdktrace.RecordAndSample, sdktrace.Drop},
+		},
+		{
+			name:    "rate below one per second, too early",
+			perSec:  0.5,
+			elapsed: time.Second,
+			want:    []sdktrace.SamplingDecision{sdktrace.Drop},
+		},
+		{
+			name:    "bucket holds one second worth",
+			perSec:  2,
+			elapsed: 10 * time.Second,
+			want:    []sdktrace.SamplingDecision{sdktrace.RecordAndSample, sdktrace.RecordAndSample, sdktrace.Drop},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRateLimitingSampler(tt.perSec)
+			s.tokens = 0
+			s.lastTime = time.Now().Add(-tt.elapsed)
+
+			for i, want := range tt.want {
+				if got := s.ShouldSample(sdktrace.SamplingParameters{}).Decision; got != want {
+					t.Errorf("decision %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
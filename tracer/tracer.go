@@ -1,57 +1,98 @@
 package trcer
 
 import (
+	"ars-projekat/config"
 	"context"
-	"fmt"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/opentracing/opentracing-go/log"
 	"github.com/uber/jaeger-client-go"
-	"github.com/uber/jaeger-client-go/config"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
 	jaegerlog "github.com/uber/jaeger-client-go/log"
 	"github.com/uber/jaeger-lib/metrics"
+	"go.opentelemetry.io/otel/trace"
 	"io"
+	stdlog "log"
 	"net/http"
+	"os"
 )
 
-// Init returns an instance of Jaeger Tracer.
-func Init(service string) (opentracing.Tracer, io.Closer) {
-	cfg, err := config.FromEnv()
+// Init returns the tracer selected by cfg.Exporter: "otlp" for
+// OpenTelemetry, "jaeger" for the Jaeger client or "none". Without one, OTLP
+// is used when an OTLP endpoint is set and Jaeger when a Jaeger agent or
+// collector is, and tracing is otherwise disabled. A tracer that fails to
+// start is replaced by a no-op one rather than stopping the service.
+func Init(cfg config.TracingConfig) (opentracing.Tracer, io.Closer) {
+	exporter := cfg.Exporter
+	if exporter == "" {
+		switch {
+		case os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "":
+			exporter = config.ExporterOTLP
+		case os.Getenv("JAEGER_AGENT_HOST") != "" || os.Getenv("JAEGER_ENDPOINT") != "":
+			exporter = config.ExporterJaeger
+		default:
+			exporter = config.ExporterNone
+		}
+	}
+
+	routeRates = cfg.Sampler.Routes
+
+	var tracer opentracing.Tracer
+	var closer io.Closer
+	var err error
+	switch exporter {
+	case config.ExporterOTLP:
+		tracer, closer, err = initOTel(cfg.ServiceName, cfg.Sampler)
+	case config.ExporterJaeger:
+		tracer, closer, err = initJaeger(cfg.ServiceName, cfg.Sampler)
+	default:
+		return noop()
+	}
+
+	if err != nil {
+		stdlog.Printf("tracing disabled: %v", err)
+		return noop()
+	}
+
+	return tracer, closer
+}
+
+// initJaeger configures the Jaeger client from the JAEGER_* variables. A
+// configured sampler takes precedence over JAEGER_SAMPLER_*, and without
+// either every trace is sampled. Jaeger samplers always follow the parent's
+// decision.
+func initJaeger(service string, sampling config.SamplerConfig) (opentracing.Tracer, io.Closer, error) {
+	cfg, err := jaegercfg.FromEnv()
 	if err != nil {
-		fmt.Println(err.Error())
-		return nil, nil
+		return nil, nil, err
 	}
 
 	cfg.ServiceName = service
-	cfg.Sampler.Type = jaeger.SamplerTypeConst
-	cfg.Sampler.Param = 1
-	cfg.Reporter.LogSpans = true
-
-	//Without docker, easier to test
-	// cfg := &config.Configuration{
-	// 	ServiceName: service,
-
-	// 	// "const" sampler is a binary sampling strategy: 0=never sample, 1=always sample.
-	// 	Sampler: &config.SamplerConfig{
-	// 		Type:  jaeger.SamplerTypeConst,
-	// 		Param: 1,
-	// 	},
-
-	// 	// Log the emitted spans to stdout.
-	// 	Reporter: &config.ReporterConfig{
-	// 		LogSpans: true,
-	// 	},
-	// }
+	if sampling.Type != "" {
+		cfg.Sampler.Type = sampling.Type
+		cfg.Sampler.Param = sampling.Param
+	} else if os.Getenv("JAEGER_SAMPLER_TYPE") == "" {
+		cfg.Sampler.Type = jaeger.SamplerTypeConst
+		cfg.Sampler.Param = 1
+	}
+
 	jLogger := jaegerlog.StdLogger
 	jMetricsFactory := metrics.NullFactory
-	tracer, closer, err := cfg.NewTracer(
-		config.Logger(jLogger),
-		config.Metrics(jMetricsFactory),
+	return cfg.NewTracer(
+		jaegercfg.Logger(jLogger),
+		jaegercfg.Metrics(jMetricsFactory),
 	)
-	if err != nil {
-		panic(fmt.Sprintf("ERROR: cannot init Jaeger: %v\n", err))
-	}
-	return tracer, closer
+}
+
+// Enabled reports whether tracer records spans, rather than being the no-op
+// tracer Init falls back to.
+func Enabled(tracer opentracing.Tracer) bool {
+	_, isNoop := tracer.(opentracing.NoopTracer)
+	return tracer != nil && !isNoop
+}
+
+func noop() (opentracing.Tracer, io.Closer) {
+	return opentracing.NoopTracer{}, closerFunc(func() error { return nil })
 }
 
 // Inject injects the outbound HTTP request with the given span's context to ensure
@@ -71,11 +112,26 @@ func Extract(tracer opentracing.Tracer, r *http.Request) (opentracing.SpanContex
 		opentracing.HTTPHeadersCarrier(r.Header))
 }
 
-// StartSpanFromRequest extracts the parent span context from the inbound HTTP request
-// and starts a new child span if there is a parent span.
+// StartSpanFromRequest starts a child of the span carried by the request
+// context, as set by a wrapping middleware. Failing that, it extracts the
+// parent span context from the inbound HTTP request and starts a new child
+// span if there is a parent span. A span without a parent starts the trace, so
+// it carries the sampling override of its route.
 func StartSpanFromRequest(spanName string, tracer opentracing.Tracer, r *http.Request) opentracing.Span {
+	if parent := opentracing.SpanFromContext(r.Context()); parent != nil {
+		return tracer.StartSpan(spanName, opentracing.ChildOf(parent.Context()))
+	}
+
 	spanCtx, _ := Extract(tracer, r)
-	return tracer.StartSpan(spanName, ext.RPCServerOption(spanCtx))
+
+	opts := []opentracing.StartSpanOption{ext.RPCServerOption(spanCtx)}
+	if spanCtx == nil {
+		if tag, ok := routeSampling(r); ok {
+			opts = append(opts, tag)
+		}
+	}
+
+	return tracer.StartSpan(spanName, opts...)
 }
 
 func StartSpanFromContext(ctx context.Context, spanName string) opentracing.Span {
@@ -94,3 +150,48 @@ func LogString(key string, value string) log.Field {
 func LogError(span opentracing.Span, err error, fields ...log.Field) {
 	ext.LogError(span, err, fields...)
 }
+
+// TraceID returns the id of the trace the span in ctx belongs to, or an empty
+// string when there is none.
+func TraceID(ctx context.Context) string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+
+	switch sc := span.Context().(type) {
+	case jaeger.SpanContext:
+		return sc.TraceID().String()
+	case interface{ TraceID() trace.TraceID }:
+		// Spans of the OpenTelemetry bridge.
+		if id := sc.TraceID(); id.IsValid() {
+			return id.String()
+		}
+	}
+
+	return ""
+}
+
+// SpanID returns the id of the span in ctx, or an empty string when there is
+// none.
+func SpanID(ctx context.Context) string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+
+	switch sc := span.Context().(type) {
+	case jaeger.SpanContext:
+		return sc.SpanID().String()
+	case interface{ SpanID() trace.SpanID }:
+		if id := sc.SpanID(); id.IsValid() {
+			return id.String()
+		}
+	}
+
+	return ""
+}
+
+func SpanFromContext(ctx context.Context) opentracing.Span {
+	return opentracing.SpanFromContext(ctx)
+}